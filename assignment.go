@@ -0,0 +1,40 @@
+package molasses
+
+// Assignment - The group, or named variant, a user was placed in for a feature.
+// A feature is active for every assignment except AssignmentControl.
+type Assignment string
+
+var (
+	AssignmentControl    Assignment = "control"    // AssignmentControl - the feature is not active for the user
+	AssignmentExperiment Assignment = "experiment" // AssignmentExperiment - the feature is active for the user
+)
+
+// Group - AssignmentControl for the control group and AssignmentExperiment for the experiment group
+// and every named variant
+func (a Assignment) Group() Assignment {
+	if a == AssignmentControl {
+		return AssignmentControl
+	}
+	return AssignmentExperiment
+}
+
+// variantName is the name of a named variant, or empty for the control and experiment groups
+func (a Assignment) variantName() string {
+	if a == AssignmentControl || a == AssignmentExperiment {
+		return ""
+	}
+	return string(a)
+}
+
+// testType labels an assignment's group in analytics events. Older versions of this SDK sent the
+// labels the wrong way around, legacy keeps doing so for dashboards that were built on them.
+func testType(a Assignment, legacy bool) string {
+	group := a.Group()
+	if legacy {
+		if group == AssignmentControl {
+			return string(AssignmentExperiment)
+		}
+		return string(AssignmentControl)
+	}
+	return string(group)
+}
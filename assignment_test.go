@@ -0,0 +1,90 @@
+package molasses_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const assignmentPayload = `{"data":{"features":[{"id":"1","key":"CHECKOUT","active":true,"rules":[
+	{"id":"enterprise","variant":"one-page","userConstraints":[{"operator":"equals","values":"enterprise","userParam":"plan"}]},
+	{"id":"team","userConstraints":[{"operator":"equals","values":"team","userParam":"plan"}]}]}]}}`
+
+func TestEventsAreLabelledWithAssignment(t *testing.T) {
+	tests := []struct {
+		name     string
+		plan     string
+		legacy   bool
+		active   bool
+		testType string
+		variant  string
+	}{
+		{"experiment", "team", false, true, "experiment", ""},
+		{"control", "free", false, false, "control", ""},
+		{"named variant", "enterprise", false, true, "experiment", "one-page"},
+		{"legacy experiment", "team", true, true, "control", ""},
+		{"legacy control", "free", true, false, "experiment", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, events := newEventsClient(t, molasses.ClientOptions{
+				AutoSendEvents:    true,
+				LegacyTestTypes:   test.legacy,
+				ExposureCacheSize: -1,
+			}, assignmentPayload)
+			user := molasses.User{ID: "1234", Params: map[string]interface{}{"plan": test.plan}}
+
+			assert.Equal(t, test.active, client.IsActive("CHECKOUT", user))
+			client.ExperimentStarted("CHECKOUT", user, nil)
+			client.ExperimentSuccess("CHECKOUT", user, nil)
+			// events are uploaded concurrently so they can arrive in any order
+			var names []string
+			for i := 0; i < 3; i++ {
+				event := <-events
+				names = append(names, event.Event)
+				assert.Equal(t, test.testType, event.TestType)
+				assert.Equal(t, test.variant, event.Variant)
+			}
+			assert.ElementsMatch(t, []string{"experiment_started", "experiment_started", "experiment_success"}, names)
+		})
+	}
+}
+
+func TestEvaluationDetailAssignment(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+	detail := client.IsActiveDetail("CHECKOUT", molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "enterprise"}})
+	assert.Equal(t, molasses.Assignment("one-page"), detail.Assignment)
+	assert.Equal(t, molasses.AssignmentExperiment, detail.Assignment.Group())
+
+	detail = client.IsActiveDetail("MISSING", molasses.User{ID: "1234"})
+	assert.Equal(t, molasses.AssignmentControl, detail.Assignment)
+}
+
+func TestExposuresAreSentOncePerUser(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{AutoSendEvents: true}, assignmentPayload)
+	team := molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "team"}}
+	enterprise := molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "enterprise"}}
+
+	for i := 0; i < 10; i++ {
+		client.IsActive("CHECKOUT", team)
+	}
+	client.ExperimentStarted("CHECKOUT", team, nil)
+	// the same user in a different variant is a new exposure
+	client.IsActive("CHECKOUT", enterprise)
+	// success events are never deduplicated
+	client.ExperimentSuccess("CHECKOUT", team, nil)
+	client.ExperimentSuccess("CHECKOUT", team, nil)
+
+	var names []string
+	for i := 0; i < 4; i++ {
+		names = append(names, (<-events).Event)
+	}
+	assert.ElementsMatch(t, []string{"experiment_started", "experiment_started", "experiment_success", "experiment_success"}, names)
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %s event", event.Event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,284 @@
+package molasses_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const benchmarkPayload = `{"data":{"features":[{"id":"1","key":"CHECKOUT","active":true,"segments":[
+	{"segmentType":"alwaysControl","constraint":"any","userConstraints":[
+		{"operator":"in","values":"CN,RU,IR,KP","userParam":"country","userParamType":""},
+		{"operator":"equals","values":"true","userParam":"blocked","userParamType":"bool"}]},
+	{"segmentType":"alwaysExperiment","userConstraints":[
+		{"operator":"gte","values":"50","userParam":"seats","userParamType":"number"},
+		{"operator":"nin","values":"free,trial","userParam":"plan","userParamType":""},
+		{"operator":"gte","values":"v2.1.0","userParam":"version","userParamType":"semver"}]},
+	{"segmentType":"everyoneElse","percentage":50}]}]}}`
+
+type testServer interface {
+	Fatal(args ...interface{})
+	Error(args ...interface{})
+	Cleanup(func())
+}
+
+func newBenchmarkClient(tb testServer, payload string) molasses.ClientInterface {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if _, err := rw.Write([]byte(payload)); err != nil {
+			tb.Error(err)
+		}
+	}))
+	tb.Cleanup(server.Close)
+
+	client, err := molasses.Init(molasses.ClientOptions{
+		HTTPClient: server.Client(),
+		Polling:    true,
+		APIKey:     "API_KEY",
+		URL:        server.URL,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(client.Stop)
+	return client
+}
+
+// benchmarkUsers is passed with users... because a variadic argument built at the call site
+// escapes through ClientInterface and would be counted as an allocation of IsActive
+var benchmarkUsers = []molasses.User{{
+	ID: "1234",
+	Params: map[string]interface{}{
+		"country": "US",
+		"blocked": false,
+		"seats":   75,
+		"plan":    "enterprise",
+		"version": "v2.3.0",
+	},
+}}
+
+// payloadOptions describes a synthetic payload
+type payloadOptions struct {
+	features    int
+	constraints int
+	// constraint is the template for every constraint, its userParam gets the constraint's index appended
+	constraint map[string]string
+	// constraintMode is the segment's constraint, "any" or "all"
+	constraintMode string
+	// segments adds named segments, each feature's constraints then reference one through the segment operator
+	segments int
+}
+
+// generatePayload builds a features payload where every feature has an alwaysControl segment with
+// the requested constraints followed by an everyoneElse rollout of 50%
+func generatePayload(o payloadOptions) string {
+	constraints := func() []map[string]string {
+		list := make([]map[string]string, o.constraints)
+		for i := range list {
+			c := map[string]string{}
+			for k, v := range o.constraint {
+				c[k] = v
+			}
+			c["userParam"] = o.constraint["userParam"] + strconv.Itoa(i)
+			list[i] = c
+		}
+		return list
+	}
+
+	segments := make([]map[string]interface{}, o.segments)
+	for i := range segments {
+		segments[i] = map[string]interface{}{
+			"id":              fmt.Sprintf("segment-%d", i),
+			"constraint":      o.constraintMode,
+			"userConstraints": constraints(),
+		}
+	}
+
+	features := make([]map[string]interface{}, o.features)
+	for i := range features {
+		userConstraints := constraints()
+		if o.segments > 0 {
+			userConstraints = []map[string]string{{"operator": "segment", "values": fmt.Sprintf("segment-%d", i%o.segments)}}
+		}
+		features[i] = map[string]interface{}{
+			"id":     strconv.Itoa(i),
+			"key":    fmt.Sprintf("FEATURE_%d", i),
+			"active": true,
+			"segments": []map[string]interface{}{
+				{"segmentType": "alwaysControl", "constraint": o.constraintMode, "userConstraints": userConstraints},
+				{"segmentType": "everyoneElse", "percentage": 50},
+			},
+		}
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{"features": features, "segments": segments},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return string(payload)
+}
+
+// userFor builds a user with a value for every param the generated constraints reference
+func userFor(o payloadOptions, value interface{}) []molasses.User {
+	params := map[string]interface{}{}
+	for i := 0; i < o.constraints; i++ {
+		params[o.constraint["userParam"]+strconv.Itoa(i)] = value
+	}
+	return []molasses.User{{ID: "1234", Params: params}}
+}
+
+var operatorBenchmarks = []struct {
+	name       string
+	constraint map[string]string
+	value      interface{}
+}{
+	{"equals", map[string]string{"operator": "equals", "values": "enterprise", "userParam": "plan", "userParamType": ""}, "team"},
+	{"in", map[string]string{"operator": "in", "values": "AT,BE,BG,CY,CZ,DE,DK,EE,ES,FI,FR,GR,HR,HU,IE,IT,LT,LU,LV,MT,NL,PL,PT,RO,SE,SI,SK", "userParam": "country", "userParamType": ""}, "US"},
+	{"contains", map[string]string{"operator": "contains", "values": "@molasses.app", "userParam": "email", "userParamType": ""}, "jane@example.com"},
+	{"number", map[string]string{"operator": "gte", "values": "50", "userParam": "seats", "userParamType": "number"}, 10},
+	{"number from string", map[string]string{"operator": "gte", "values": "50", "userParam": "seats", "userParamType": "number"}, "10"},
+	{"bool", map[string]string{"operator": "equals", "values": "true", "userParam": "beta", "userParamType": "bool"}, false},
+	{"semver", map[string]string{"operator": "gte", "values": "v2.1.0", "userParam": "version", "userParamType": "semver"}, "v1.9.3"},
+	{"exists", map[string]string{"operator": "notExists", "values": "", "userParam": "teamId", "userParamType": ""}, "123"},
+}
+
+func BenchmarkIsActive(b *testing.B) {
+	client := newBenchmarkClient(b, benchmarkPayload)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.IsActive("CHECKOUT", benchmarkUsers...)
+	}
+}
+
+func BenchmarkIsActiveWithoutUser(b *testing.B) {
+	client := newBenchmarkClient(b, benchmarkPayload)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.IsActive("CHECKOUT")
+	}
+}
+
+func BenchmarkIsActiveFlagCount(b *testing.B) {
+	for _, count := range []int{10, 100, 1000, 10000} {
+		o := payloadOptions{features: count, constraints: 3, constraint: operatorBenchmarks[0].constraint}
+		b.Run(strconv.Itoa(count), func(b *testing.B) {
+			client := newBenchmarkClient(b, generatePayload(o))
+			users := userFor(o, "team")
+			key := fmt.Sprintf("FEATURE_%d", count/2)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				client.IsActive(key, users...)
+			}
+		})
+	}
+}
+
+func BenchmarkIsActiveConstraintCount(b *testing.B) {
+	for _, count := range []int{1, 10, 50, 200} {
+		o := payloadOptions{features: 1, constraints: count, constraint: operatorBenchmarks[0].constraint, constraintMode: "any"}
+		b.Run(strconv.Itoa(count), func(b *testing.B) {
+			client := newBenchmarkClient(b, generatePayload(o))
+			// every constraint has to be checked because none of them match
+			users := userFor(o, "team")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				client.IsActive("FEATURE_0", users...)
+			}
+		})
+	}
+}
+
+func BenchmarkIsActiveOperator(b *testing.B) {
+	for _, op := range operatorBenchmarks {
+		o := payloadOptions{features: 1, constraints: 5, constraint: op.constraint, constraintMode: "any"}
+		b.Run(op.name, func(b *testing.B) {
+			client := newBenchmarkClient(b, generatePayload(o))
+			users := userFor(o, op.value)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				client.IsActive("FEATURE_0", users...)
+			}
+		})
+	}
+}
+
+func BenchmarkIsActiveNamedSegments(b *testing.B) {
+	o := payloadOptions{features: 100, constraints: 5, constraint: operatorBenchmarks[1].constraint, segments: 10}
+	client := newBenchmarkClient(b, generatePayload(o))
+	users := userFor(o, "US")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.IsActive("FEATURE_42", users...)
+	}
+}
+
+func BenchmarkIsActiveParallel(b *testing.B) {
+	o := payloadOptions{features: 1000, constraints: 10, constraint: operatorBenchmarks[1].constraint}
+	client := newBenchmarkClient(b, generatePayload(o))
+	users := userFor(o, "US")
+	keys := make([]string, o.features)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("FEATURE_%d", i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			client.IsActive(keys[i%len(keys)], users...)
+			i++
+		}
+	})
+}
+
+// BenchmarkIsActiveParallelSingleKey evaluates the same feature from every goroutine, so every evaluation
+// updates the same usage counters
+func BenchmarkIsActiveParallelSingleKey(b *testing.B) {
+	client := newBenchmarkClient(b, benchmarkPayload)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			client.IsActive("CHECKOUT", benchmarkUsers...)
+		}
+	})
+}
+
+func TestGeneratedPayloadIsEvaluated(t *testing.T) {
+	o := payloadOptions{features: 50, constraints: 2, constraint: operatorBenchmarks[0].constraint, segments: 5}
+	client := newBenchmarkClient(t, generatePayload(o))
+	assert.True(t, client.IsInitiated())
+	// enterprise users are always in the control group
+	detail := client.IsActiveDetail("FEATURE_7", userFor(o, "enterprise")...)
+	assert.Equal(t, molasses.ReasonAlwaysControl, detail.Reason)
+	detail = client.IsActiveDetail("FEATURE_7", userFor(o, "team")...)
+	assert.Equal(t, molasses.ReasonPercentage, detail.Reason)
+}
+
+// TestIsActiveDoesNotAllocate guards against allocations creeping back into the evaluator
+func TestIsActiveDoesNotAllocate(t *testing.T) {
+	for _, op := range operatorBenchmarks {
+		t.Run(op.name, func(t *testing.T) {
+			o := payloadOptions{features: 10, constraints: 5, constraint: op.constraint, constraintMode: "any", segments: 2}
+			client := newBenchmarkClient(t, generatePayload(o))
+			users := userFor(o, op.value)
+			allocs := testing.AllocsPerRun(100, func() {
+				client.IsActive("FEATURE_3", users...)
+			})
+			assert.Equal(t, 0.0, allocs)
+		})
+	}
+}
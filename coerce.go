@@ -0,0 +1,138 @@
+package molasses
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// TypeMismatchError - Returned in the evaluation details when a user param can not be used as the type its constraint expects
+type TypeMismatchError struct {
+	Param    string      // Param is the name of the user param
+	Expected string      // Expected is the userParamType of the constraint (string, number, bool or semver)
+	Value    interface{} // Value is the value the user param was set to
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("user param %s has a value of type %T which can not be used as a %s", e.Param, e.Value, e.Expected)
+}
+
+var errNotValidValue = errors.New("not valid value")
+
+// toFloat64 converts a user param value to a number.
+// Every Go integer and float kind, json.Number, time.Duration (as seconds) and time.Time (as Unix seconds) are numbers.
+// Unless strict is set, numeric strings are parsed as well.
+func toFloat64(value interface{}, strict bool) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case time.Duration:
+		return v.Seconds(), nil
+	case time.Time:
+		return float64(v.UnixNano()) / float64(time.Second), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		if strict {
+			return 0.0, errNotValidValue
+		}
+		return strconv.ParseFloat(v, 64)
+	case bool, nil:
+		return 0.0, errNotValidValue
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		if strict {
+			return 0.0, errNotValidValue
+		}
+		return strconv.ParseFloat(rv.String(), 64)
+	}
+	return 0.0, errNotValidValue
+}
+
+// toBool converts a user param value to a bool.
+// Unless strict is set, strings are parsed with strconv.ParseBool and numbers are true when they are greater than 0.
+func toBool(value interface{}, strict bool) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if strict {
+			return false, errNotValidValue
+		}
+		return strconv.ParseBool(v)
+	case nil:
+		return false, errNotValidValue
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Bool {
+		return rv.Bool(), nil
+	}
+	if strict {
+		return false, errNotValidValue
+	}
+	if rv.Kind() == reflect.String {
+		return strconv.ParseBool(rv.String())
+	}
+	n, err := toFloat64(value, true)
+	if err != nil {
+		return false, err
+	}
+	return n > 0.0, nil
+}
+
+// toString converts a user param value to a string.
+// Unless strict is set, bools and numbers are formatted as strings and times are formatted as RFC 3339.
+func toString(value interface{}, strict bool) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", errNotValidValue
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.String {
+		if _, ok := value.(json.Number); !ok || !strict {
+			return rv.String(), nil
+		}
+	}
+	if strict {
+		return "", errNotValidValue
+	}
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Duration:
+		return strconv.FormatFloat(v.Seconds(), 'f', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64), nil
+	}
+	return "", errNotValidValue
+}
@@ -0,0 +1,211 @@
+package molasses
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compiledFeature is a feature prepared for evaluation when its payload is loaded, so that
+// evaluating it does not need to parse constraint values or allocate
+type compiledFeature struct {
+	feature
+	rules             []compiledRule
+	fallthroughReason EvaluationReason
+	// cyclic is set when the feature's prerequisites depend on itself
+	cyclic bool
+	usage  *flagUsage
+}
+
+type compiledRule struct {
+	id        string
+	segmentID string
+	node      compiledNode
+	// percentage of matching users the rule applies to, or -1 for every matching user
+	percentage int
+	variant    Assignment
+	reason     EvaluationReason
+}
+
+type compiledSegment struct {
+	// index is the position of the segment's result in an evaluation's memo
+	index int
+	node  compiledNode
+}
+
+type nodeKind int
+
+const (
+	leafNode nodeKind = iota
+	allNode
+	anyNode
+	notNode
+)
+
+type compiledNode struct {
+	kind     nodeKind
+	children []compiledNode
+	leaf     compiledConstraint
+}
+
+// compiledConstraint is a user constraint with its values parsed for its userParamType
+type compiledConstraint struct {
+	userConstraint
+	// valid is false when the values can not be parsed, the constraint then never matches
+	valid      bool
+	number     float64
+	boolean    bool
+	values     map[string]struct{}
+	segmentIDs []string
+	// versions holds the normalized semver values, one for comparisons or several for in and nin
+	versions     []string
+	versionRange versionRange
+}
+
+// newEvaluator compiles the features and segments of a payload. The returned errors describe
+// problems with the payload, such as prerequisite cycles, they do not stop it from being used.
+func newEvaluator(features map[string]feature, segments map[string]userSegment, strict bool) (*evaluator, []error) {
+	ev := &evaluator{
+		raw:         features,
+		rawSegments: segments,
+		features:    make(map[string]*compiledFeature, len(features)),
+		segments:    make(map[string]*compiledSegment, len(segments)),
+		strict:      strict,
+	}
+	c := &compiler{}
+
+	for id, s := range segments {
+		c.context = "segment " + id
+		ev.segments[id] = &compiledSegment{
+			index: len(ev.segments),
+			node:  c.compileTreeOrConstraints(s.Constraints, s.UserConstraints, s.Constraint),
+		}
+	}
+
+	cycles := findPrerequisiteCycles(features)
+	for key, f := range features {
+		c.context = "feature flag " + key
+		rules, fallthroughReason := f.targetingRules()
+		cf := &compiledFeature{
+			feature:           f,
+			rules:             make([]compiledRule, len(rules)),
+			fallthroughReason: fallthroughReason,
+			cyclic:            cycles[key],
+		}
+		for i, r := range rules {
+			cf.rules[i] = compiledRule{
+				id:         r.ID,
+				segmentID:  r.SegmentID,
+				node:       c.compileTreeOrConstraints(r.Constraints, r.UserConstraints, r.Constraint),
+				percentage: -1,
+				variant:    r.variant(),
+				reason:     r.reason,
+			}
+			if r.Percentage != nil {
+				cf.rules[i].percentage = *r.Percentage
+			}
+		}
+		if cf.cyclic {
+			c.errs = append(c.errs, fmt.Errorf("feature flag %s has a prerequisite cycle and will not be active", key))
+		}
+		ev.features[key] = cf
+	}
+	return ev, c.errs
+}
+
+// compiler collects the problems found while compiling a payload
+type compiler struct {
+	// context names the feature or segment being compiled in errors
+	context string
+	errs    []error
+}
+
+// compileTreeOrConstraints compiles the constraint tree when there is one and the flat list of constraints otherwise
+func (c *compiler) compileTreeOrConstraints(tree *constraintNode, userConstraints []userConstraint, constraint operator) compiledNode {
+	if tree != nil {
+		return c.compileNode(tree)
+	}
+	n := compiledNode{kind: allNode, children: make([]compiledNode, len(userConstraints))}
+	if constraint == any {
+		n.kind = anyNode
+	}
+	for i, uc := range userConstraints {
+		n.children[i] = compiledNode{kind: leafNode, leaf: c.compileConstraint(uc)}
+	}
+	return n
+}
+
+func (c *compiler) compileNode(n *constraintNode) compiledNode {
+	switch {
+	case n.Not != nil:
+		return compiledNode{kind: notNode, children: []compiledNode{c.compileNode(n.Not)}}
+	case n.All != nil:
+		return compiledNode{kind: allNode, children: c.compileNodes(n.All)}
+	case n.Any != nil:
+		return compiledNode{kind: anyNode, children: c.compileNodes(n.Any)}
+	}
+	return compiledNode{kind: leafNode, leaf: c.compileConstraint(n.userConstraint)}
+}
+
+func (c *compiler) compileNodes(nodes []constraintNode) []compiledNode {
+	compiled := make([]compiledNode, len(nodes))
+	for i := range nodes {
+		compiled[i] = c.compileNode(&nodes[i])
+	}
+	return compiled
+}
+
+func (c *compiler) compileConstraint(uc userConstraint) compiledConstraint {
+	cc := compiledConstraint{userConstraint: uc, valid: true}
+	switch uc.Operator {
+	case inSegment:
+		cc.segmentIDs = strings.Split(string(uc.Values), ",")
+		return cc
+	case exists, notExists:
+		return cc
+	}
+	switch uc.UserParamType {
+	case "number":
+		v, err := strconv.ParseFloat(string(uc.Values), 64)
+		cc.number, cc.valid = v, err == nil
+	case "bool":
+		v, err := strconv.ParseBool(string(uc.Values))
+		cc.boolean, cc.valid = v, err == nil
+	case "semver":
+		cc.valid = compileVersions(&cc)
+	default:
+		if uc.Operator == in || uc.Operator == nin {
+			list := strings.Split(string(uc.Values), ",")
+			cc.values = make(map[string]struct{}, len(list))
+			for _, v := range list {
+				cc.values[v] = struct{}{}
+			}
+		}
+	}
+	if !cc.valid {
+		c.errs = append(c.errs, fmt.Errorf("%s has an invalid %s value %q for %s, the constraint will never match", c.context, uc.UserParamType, uc.Values, uc.UserParam))
+	}
+	return cc
+}
+
+// compileVersions normalizes the semver values of a constraint, returning false when one of them is invalid
+func compileVersions(cc *compiledConstraint) bool {
+	switch cc.Operator {
+	case inRange:
+		r, err := parseVersionRange(string(cc.Values))
+		cc.versionRange = r
+		return err == nil
+	case in, nin:
+		for _, value := range strings.Split(string(cc.Values), ",") {
+			v, ok := normalizeVersion(value)
+			if !ok {
+				return false
+			}
+			cc.versions = append(cc.versions, v)
+		}
+		return true
+	}
+	v, ok := normalizeVersion(string(cc.Values))
+	cc.versions = []string{v}
+	return ok
+}
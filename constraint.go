@@ -0,0 +1,35 @@
+package molasses
+
+// constraintNode is a tree of user constraints. A node is a group when one of All, Any or Not is
+// set, otherwise it is a single constraint:
+//
+//	{"all": [{"any": [plan = enterprise, seats > 50]}, {"not": {country in CN}}]}
+type constraintNode struct {
+	All []constraintNode `json:"all"`
+	Any []constraintNode `json:"any"`
+	Not *constraintNode  `json:"not"`
+	userConstraint
+}
+
+// meetsNode evaluates a compiled constraint tree, groups stop as soon as their result is known
+func (e *evaluation) meetsNode(n *compiledNode) bool {
+	switch n.kind {
+	case notNode:
+		return !e.meetsNode(&n.children[0])
+	case allNode:
+		for i := range n.children {
+			if !e.meetsNode(&n.children[i]) {
+				return false
+			}
+		}
+		return true
+	case anyNode:
+		for i := range n.children {
+			if e.meetsNode(&n.children[i]) {
+				return true
+			}
+		}
+		return false
+	}
+	return e.meetsConstraint(&n.leaf)
+}
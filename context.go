@@ -0,0 +1,141 @@
+package molasses
+
+import (
+	"context"
+	"sync"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	evaluationCacheContextKey
+)
+
+// WithUser - Returns a copy of the context that carries the user, it is evaluated by the Ctx methods of the client
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext - Returns the user set by WithUser, and whether one was set
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userContextKey).(User)
+	return user, ok
+}
+
+// WithEvaluationCache - Returns a copy of the context that remembers the features evaluated with it.
+// Evaluating a feature again with the context, or a context derived from it, returns the first result
+// for the same user, so a feature keeps its value for the whole request even if it changes meanwhile.
+func WithEvaluationCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, evaluationCacheContextKey, &evaluationCache{details: map[evaluationCacheKey]EvaluationDetail{}})
+}
+
+type evaluationCacheKey struct {
+	key    string
+	userID string
+	noUser bool
+}
+
+// evaluationCache holds the evaluations made with a context, in the order they were made
+type evaluationCache struct {
+	mu      sync.Mutex
+	details map[evaluationCacheKey]EvaluationDetail
+	order   []evaluationCacheKey
+}
+
+func evaluationCacheFromContext(ctx context.Context) *evaluationCache {
+	cache, _ := ctx.Value(evaluationCacheContextKey).(*evaluationCache)
+	return cache
+}
+
+func (c *evaluationCache) get(key evaluationCacheKey) (EvaluationDetail, bool) {
+	if c == nil {
+		return EvaluationDetail{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	detail, ok := c.details[key]
+	return detail, ok
+}
+
+func (c *evaluationCache) set(key evaluationCacheKey, detail EvaluationDetail) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.details[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.details[key] = detail
+}
+
+// IsActiveCtx - Check to see if a feature is active for the user set in the context with WithUser.
+// The feature is evaluated without a user when the context does not carry one.
+func (c *client) IsActiveCtx(ctx context.Context, key string) bool {
+	return c.IsActiveDetailCtx(ctx, key).Active
+}
+
+// IsActiveDetailCtx - Check to see if a feature is active for the user set in the context and get the reason
+// for the result. The result is cached when the context has an evaluation cache, see WithEvaluationCache.
+func (c *client) IsActiveDetailCtx(ctx context.Context, key string) EvaluationDetail {
+	return c.evaluateCtx(ctx, c.evaluator(), key)
+}
+
+// evaluateCtx evaluates a feature for the user in the context, unless the context's cache already has the result
+func (c *client) evaluateCtx(ctx context.Context, ev *evaluator, key string) EvaluationDetail {
+	user, hasUser := UserFromContext(ctx)
+	cacheKey := evaluationCacheKey{key: key, userID: user.ID, noUser: !hasUser}
+	cache := evaluationCacheFromContext(ctx)
+	if detail, ok := cache.get(cacheKey); ok {
+		return detail
+	}
+	var detail EvaluationDetail
+	if hasUser {
+		detail = c.evaluate(ev, key, user)
+	} else {
+		detail = c.evaluate(ev, key)
+	}
+	cache.set(cacheKey, detail)
+	return detail
+}
+
+// TrackCtx - Track an event for the user set in the context.
+// The event is dropped when the context is already done, cancelling the context later does not stop it being sent.
+func (c *client) TrackCtx(ctx context.Context, eventName string, additionalDetails map[string]interface{}) {
+	if ctx.Err() != nil {
+		return
+	}
+	user, _ := UserFromContext(ctx)
+	c.Track(eventName, user, additionalDetails)
+}
+
+// TrackMetricCtx - Track an event with a numeric value for the user set in the context.
+// The event is dropped when the context is already done, cancelling the context later does not stop it being sent.
+func (c *client) TrackMetricCtx(ctx context.Context, eventName string, value float64, additionalDetails map[string]interface{}) {
+	if ctx.Err() != nil {
+		return
+	}
+	user, _ := UserFromContext(ctx)
+	c.TrackMetric(eventName, user, value, additionalDetails)
+}
+
+// ExperimentStartedCtx - Track that the user set in the context started an experiment.
+// The event is dropped when the context is already done, cancelling the context later does not stop it being sent.
+func (c *client) ExperimentStartedCtx(ctx context.Context, key string, additionalDetails map[string]interface{}) {
+	if ctx.Err() != nil {
+		return
+	}
+	user, _ := UserFromContext(ctx)
+	c.ExperimentStarted(key, user, additionalDetails)
+}
+
+// ExperimentSuccessCtx - Track that the user set in the context succeeded in an experiment.
+// The event is dropped when the context is already done, cancelling the context later does not stop it being sent.
+func (c *client) ExperimentSuccessCtx(ctx context.Context, key string, additionalDetails map[string]interface{}) {
+	if ctx.Err() != nil {
+		return
+	}
+	user, _ := UserFromContext(ctx)
+	c.ExperimentSuccess(key, user, additionalDetails)
+}
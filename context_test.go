@@ -0,0 +1,129 @@
+package molasses_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserFromContext(t *testing.T) {
+	_, ok := molasses.UserFromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := molasses.WithUser(context.Background(), molasses.User{ID: "1234"})
+	user, ok := molasses.UserFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "1234", user.ID)
+}
+
+func TestIsActiveCtx(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+
+	ctx := molasses.WithUser(context.Background(), molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "enterprise"}})
+	detail := client.IsActiveDetailCtx(ctx, "CHECKOUT")
+	assert.Equal(t, molasses.ReasonRuleMatch, detail.Reason)
+	assert.Equal(t, molasses.Assignment("one-page"), detail.Assignment)
+	assert.True(t, client.IsActiveCtx(ctx, "CHECKOUT"))
+
+	ctx = molasses.WithUser(context.Background(), molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "free"}})
+	assert.False(t, client.IsActiveCtx(ctx, "CHECKOUT"))
+
+	// without a user in the context the feature is evaluated without one
+	assert.Equal(t, molasses.ReasonNoUser, client.IsActiveDetailCtx(context.Background(), "CHECKOUT").Reason)
+}
+
+func TestEvaluationCache(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+	ctx := molasses.WithEvaluationCache(context.Background())
+	team := molasses.WithUser(ctx, molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "team"}})
+	free := molasses.WithUser(ctx, molasses.User{ID: "5678", Params: map[string]interface{}{"plan": "free"}})
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, client.IsActiveCtx(team, "CHECKOUT"))
+		assert.False(t, client.IsActiveCtx(free, "CHECKOUT"))
+		assert.True(t, client.IsActiveCtx(ctx, "CHECKOUT"))
+	}
+	// every user is only evaluated once
+	assert.Equal(t, int64(3), client.Usage()["CHECKOUT"].Evaluations)
+
+	// contexts without a cache evaluate every time
+	client.IsActiveCtx(molasses.WithUser(context.Background(), molasses.User{ID: "1234"}), "CHECKOUT")
+	client.IsActiveCtx(molasses.WithUser(context.Background(), molasses.User{ID: "1234"}), "CHECKOUT")
+	assert.Equal(t, int64(5), client.Usage()["CHECKOUT"].Evaluations)
+}
+
+func TestTrackCtx(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+	ctx := molasses.WithUser(context.Background(), molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "team"}})
+
+	client.TrackCtx(ctx, "checkout_viewed", map[string]interface{}{"page": "cart"})
+	event := <-events
+	assert.Equal(t, "checkout_viewed", event.Event)
+	assert.Equal(t, "1234", event.UserID)
+	assert.Equal(t, map[string]interface{}{"plan": "team", "page": "cart"}, event.Tags)
+
+	client.TrackMetricCtx(ctx, "revenue", 12.5, nil)
+	event = <-events
+	assert.Equal(t, "revenue", event.Event)
+	assert.Equal(t, 12.5, *event.Value)
+
+	client.ExperimentSuccessCtx(ctx, "CHECKOUT", nil)
+	event = <-events
+	assert.Equal(t, "experiment_success", event.Event)
+	assert.Equal(t, "experiment", event.TestType)
+
+	// cancelling the context after tracking does not stop the event
+	request, cancel := context.WithCancel(ctx)
+	client.TrackCtx(request, "checkout_submitted", nil)
+	cancel()
+	assert.Equal(t, "checkout_submitted", (<-events).Event)
+
+	// nothing is sent once the context is done
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	client.TrackCtx(cancelled, "checkout_viewed", nil)
+	client.ExperimentStartedCtx(cancelled, "CHECKOUT", nil)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected %s event", event.Event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStopCancelsFetches(t *testing.T) {
+	var fetches int32
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			if _, err := rw.Write([]byte(eventTagsPayload)); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		<-req.Context().Done()
+		close(cancelled)
+	}))
+	defer server.Close()
+
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:       "API_KEY",
+		URL:          server.URL,
+		Polling:      true,
+		PollInterval: 5 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) > 1 }, time.Second, 5*time.Millisecond)
+	client.Stop()
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the fetch in progress was not cancelled")
+	}
+}
@@ -0,0 +1,93 @@
+package molasses
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrUnauthorized   = errors.New("Molasses is Unauthorized")                  // ErrUnauthorized - Molasses rejected the API key
+	ErrNotInitialized = errors.New("Molasses has not sent any features yet")    // ErrNotInitialized - the client was used before it received features
+	ErrInvalidPayload = errors.New("Molasses sent an invalid features payload") // ErrInvalidPayload - a payload could not be used, the previous features are kept
+	ErrRateLimited    = errors.New("Molasses is rate limiting requests")        // ErrRateLimited - Molasses responded with 429 Too Many Requests
+	// ErrInvalidMetricValue - TrackMetric was given NaN or an infinite value, which can not be sent as JSON
+	ErrInvalidMetricValue = errors.New("Molasses can only track finite metric values")
+)
+
+// InvalidPayloadError - A features payload that could not be decoded or is missing required fields.
+// errors.Is reports it as ErrInvalidPayload.
+type InvalidPayloadError struct {
+	Err error
+}
+
+func (e *InvalidPayloadError) Error() string {
+	return fmt.Sprintf("%s - %s", ErrInvalidPayload.Error(), e.Err.Error())
+}
+
+func (e *InvalidPayloadError) Is(target error) bool { return target == ErrInvalidPayload }
+
+func (e *InvalidPayloadError) Unwrap() error { return e.Err }
+
+// RateLimitError - Molasses is rate limiting requests, RetryAfter is how long it asked to wait when it did.
+// errors.Is reports it as ErrRateLimited.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s, retry after %s", ErrRateLimited.Error(), e.RetryAfter)
+	}
+	return ErrRateLimited.Error()
+}
+
+func (e *RateLimitError) Is(target error) bool { return target == ErrRateLimited }
+
+// responseError checks the status code of a response from Molasses
+func responseError(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case resp.StatusCode == http.StatusTooManyRequests:
+		err := &RateLimitError{}
+		if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+			err.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return err
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("There is an issue connecting to Molasses status code - %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// decodePayload decodes a features payload, it returns an *InvalidPayloadError instead of a partial payload.
+// A field with a value of the wrong type makes the whole payload invalid, as it would otherwise be left empty.
+func decodePayload(data []byte) (features, error) {
+	var f featuresResponse
+	if err := json.Unmarshal(data, &f); err != nil {
+		return features{}, &InvalidPayloadError{Err: err}
+	}
+	for i, feature := range f.Data.Features {
+		if feature.Key == "" {
+			return features{}, &InvalidPayloadError{Err: fmt.Errorf("feature %d has no key", i)}
+		}
+	}
+	for i, segment := range f.Data.Segments {
+		if segment.ID == "" {
+			return features{}, &InvalidPayloadError{Err: fmt.Errorf("segment %d has no id", i)}
+		}
+	}
+	return f.Data, nil
+}
+
+// reportError logs an error with the format, which has a single %s verb for it, and passes it to OnError
+func (c *client) reportError(format string, err error) {
+	c.logger.Printf(format, err.Error())
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
@@ -0,0 +1,209 @@
+package molasses_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// errorRecorder collects the errors passed to OnError
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *errorRecorder) record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+// count returns how many recorded errors are target
+func (r *errorRecorder) count(target error) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, err := range r.errs {
+		if errors.Is(err, target) {
+			n++
+		}
+	}
+	return n
+}
+
+// find returns the first recorded error that is target
+func (r *errorRecorder) find(target error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, err := range r.errs {
+		if errors.Is(err, target) {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUnauthorizedError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	recorder := &errorRecorder{}
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:  "API_KEY",
+		URL:     server.URL,
+		Polling: true,
+		OnError: recorder.record,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	assert.Equal(t, molasses.ErrUnauthorized, recorder.find(molasses.ErrUnauthorized))
+	assert.True(t, errors.Is(client.Status().LastError, molasses.ErrUnauthorized))
+
+	// the client is used before it received any features
+	for i := 0; i < 100; i++ {
+		client.IsActive("GOOGLE_SSO")
+		client.IsActive("CHECKOUT")
+	}
+	// evaluations only report it once
+	assert.Equal(t, 1, recorder.count(molasses.ErrNotInitialized))
+	client.ExperimentStarted("GOOGLE_SSO", molasses.User{ID: "1234"}, nil)
+	assert.Equal(t, 2, recorder.count(molasses.ErrNotInitialized))
+}
+
+func TestRateLimitedError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Retry-After", "30")
+		rw.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	recorder := &errorRecorder{}
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:  "API_KEY",
+		URL:     server.URL,
+		Polling: true,
+		OnError: recorder.record,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	var rateLimited *molasses.RateLimitError
+	assert.True(t, errors.As(recorder.find(molasses.ErrRateLimited), &rateLimited))
+	assert.Equal(t, 30*time.Second, rateLimited.RetryAfter)
+}
+
+func TestInvalidPayloadKeepsFeatures(t *testing.T) {
+	payloads := []string{
+		featurePayload(true),
+		`{"data":{"features":[{"id":"1","key":"GOOGLE_SSO","active":false`,
+		`{"data":{"features":[{"id":"1","active":false}]}}`,
+		// a percentage of the wrong type would otherwise roll the feature out to no one
+		`{"data":{"features":[{"id":"1","key":"GOOGLE_SSO","active":true,"segments":[{"segmentType":"everyoneElse","percentage":"100"}]}]}}`,
+	}
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := int(atomic.AddInt32(&fetches, 1)) - 1
+		if _, err := rw.Write([]byte(payloads[n%len(payloads)])); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	recorder := &errorRecorder{}
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:       "API_KEY",
+		URL:          server.URL,
+		Polling:      true,
+		PollInterval: 5 * time.Millisecond,
+		OnError:      recorder.record,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) > 4 }, time.Second, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, recorder.count(molasses.ErrInvalidPayload), 3)
+	var invalid *molasses.InvalidPayloadError
+	assert.True(t, errors.As(recorder.find(molasses.ErrInvalidPayload), &invalid))
+	assert.Error(t, invalid.Err)
+	user := molasses.User{ID: "1234"}
+	for i := 0; i < 10; i++ {
+		assert.True(t, client.IsActive("GOOGLE_SSO", user))
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func TestInvalidStreamPayloadKeepsFeatures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/event-stream" {
+			return
+		}
+		rw.Header().Set("Content-Type", "text/event-stream")
+		for _, payload := range []string{
+			featurePayload(true),
+			`{"data":{"features":[{"key":`,
+			`{"data":{"features":[{"id":"1","key":"GOOGLE_SSO","active":true,"segments":[{"segmentType":"everyoneElse","percentage":"100"}]}]}}`,
+		} {
+			if _, err := fmt.Fprintf(rw, "data: %s\n\n", payload); err != nil {
+				t.Error(err)
+			}
+		}
+		rw.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	recorder := &errorRecorder{}
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:  "API_KEY",
+		URL:     server.URL,
+		OnError: recorder.record,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	assert.Eventually(t, func() bool { return recorder.count(molasses.ErrInvalidPayload) == 2 }, 2*time.Second, 5*time.Millisecond)
+	assert.True(t, client.IsInitiated())
+	assert.True(t, client.IsActive("GOOGLE_SSO", molasses.User{ID: "1234"}))
+}
+
+func TestStreamKeepAliveIsNotAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/event-stream" {
+			return
+		}
+		rw.Header().Set("Content-Type", "text/event-stream")
+		// the last payload is only loaded once the keep-alive before it has been handled
+		for _, event := range []string{"data: " + featurePayload(false), ": ping", "data: " + featurePayload(true)} {
+			if _, err := fmt.Fprintf(rw, "%s\n\n", event); err != nil {
+				t.Error(err)
+			}
+			rw.(http.Flusher).Flush()
+		}
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	recorder := &errorRecorder{}
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:  "API_KEY",
+		URL:     server.URL,
+		OnError: recorder.record,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	assert.Eventually(t, func() bool { return client.IsActive("GOOGLE_SSO") }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, molasses.StateValid, client.Status().State)
+	assert.Nil(t, recorder.find(molasses.ErrInvalidPayload))
+}
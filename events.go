@@ -0,0 +1,176 @@
+package molasses
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFlushInterval  = 10 * time.Second
+	defaultEventQueueSize = 1000
+	defaultFlushTimeout   = 5 * time.Second
+	// eventBatchSize is the most events uploaded by a single request of a flush
+	eventBatchSize = 100
+)
+
+// metricAggregate summarizes the values of a metric tracked for a user between two flushes
+type metricAggregate struct {
+	Count int     `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+func (a *metricAggregate) add(value float64) {
+	if a.Count == 0 || value < a.Min {
+		a.Min = value
+	}
+	if a.Count == 0 || value > a.Max {
+		a.Max = value
+	}
+	a.Count++
+	a.Sum += value
+}
+
+type metricKey struct {
+	event  string
+	userID string
+	tags   uint64 // tags - the hash of the event's tags, so metrics with different tags are kept apart
+}
+
+// tagsHash hashes the tags as JSON, which writes the keys of maps in order
+func tagsHash(tags map[string]interface{}) uint64 {
+	h := fnv.New64a()
+	if err := json.NewEncoder(h).Encode(tags); err != nil {
+		// tags that can not be encoded fail to upload anyway
+		return 0
+	}
+	return h.Sum64()
+}
+
+// eventQueue holds the events that are waiting to be flushed when batching is enabled. Metrics
+// tracked for the same user and name with the same tags are aggregated into a single event.
+type eventQueue struct {
+	mu     sync.Mutex
+	size   int
+	events []eventOptions
+	// metrics holds the index in events of each aggregated metric
+	metrics map[metricKey]int
+}
+
+func newEventQueue(size int) *eventQueue {
+	if size <= 0 {
+		size = defaultEventQueueSize
+	}
+	return &eventQueue{size: size, metrics: map[metricKey]int{}}
+}
+
+// push adds an event to the queue, it returns false when the queue is full and the event is dropped
+func (q *eventQueue) push(e eventOptions) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if e.Value == nil {
+		return q.append(e)
+	}
+	key := metricKey{event: e.Event, userID: e.UserID, tags: tagsHash(e.Tags)}
+	if i, ok := q.metrics[key]; ok {
+		queued := &q.events[i]
+		queued.Aggregate.add(*e.Value)
+		sum := queued.Aggregate.Sum
+		queued.Value = &sum
+		return true
+	}
+	e.Aggregate = &metricAggregate{}
+	e.Aggregate.add(*e.Value)
+	if !q.append(e) {
+		return false
+	}
+	q.metrics[key] = len(q.events) - 1
+	return true
+}
+
+func (q *eventQueue) append(e eventOptions) bool {
+	if len(q.events) >= q.size {
+		return false
+	}
+	q.events = append(q.events, e)
+	return true
+}
+
+// drain empties the queue and returns the events that were in it
+func (q *eventQueue) drain() []eventOptions {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	events := q.events
+	q.events = nil
+	q.metrics = map[metricKey]int{}
+	return events
+}
+
+// len is the number of events waiting to be flushed
+func (q *eventQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events)
+}
+
+// flushEvents sends the queued events every flush interval until the client is stopped
+func (c *client) flushEvents(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// flush sends every queued event to the analytics endpoint, in requests of up to eventBatchSize events,
+// and returns once they are sent or the flush timeout has passed
+func (c *client) flush() {
+	if c.queue == nil {
+		return
+	}
+	events := c.queue.drain()
+	ctx, cancel := context.WithTimeout(context.Background(), c.flushTimeout)
+	defer cancel()
+	for len(events) > 0 {
+		n := len(events)
+		if n > eventBatchSize {
+			n = eventBatchSize
+		}
+		if err := c.sendEvents(ctx, events[:n]); err != nil {
+			for i := 0; i < n; i++ {
+				c.metrics.IncCounter(MetricEventUploadFailures)
+			}
+			c.reportError("Error uploading events to analytics HTTP endpoint - %s", err)
+		} else {
+			for i := 0; i < n; i++ {
+				c.metrics.IncCounter(MetricEventsSent)
+			}
+		}
+		events = events[n:]
+	}
+}
+
+// sendEvents uploads the events as a JSON list in a single request and waits for the response
+func (c *client) sendEvents(ctx context.Context, events []eventOptions) error {
+	req, err := c.analyticsRequest(events)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return responseError(res)
+}
@@ -0,0 +1,266 @@
+package molasses_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventTagsPayload has a single feature that is active for everyone
+const eventTagsPayload = `{"data":{"features":[{"id":"1","key":"GOOGLE_SSO","active":true,"segments":[{"segmentType":"everyoneElse","percentage":100}]}]}}`
+
+// uploadedEvent is the part of an analytics event the tests check
+type uploadedEvent struct {
+	Event     string                 `json:"event"`
+	UserID    string                 `json:"userId"`
+	TestType  string                 `json:"testType"`
+	Variant   string                 `json:"variant"`
+	Tags      map[string]interface{} `json:"tags"`
+	Value     *float64               `json:"value"`
+	Aggregate *struct {
+		Count int     `json:"count"`
+		Sum   float64 `json:"sum"`
+		Min   float64 `json:"min"`
+		Max   float64 `json:"max"`
+	} `json:"aggregate"`
+	SampleRate float64 `json:"sampleRate"`
+}
+
+// newEventsClient starts a client whose uploaded events are sent to the returned channel
+func newEventsClient(t *testing.T, options molasses.ClientOptions, payload string) (molasses.ClientInterface, chan uploadedEvent) {
+	events := make(chan uploadedEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.String() == "/features" {
+			if _, err := rw.Write([]byte(payload)); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if bytes.HasPrefix(body, []byte("[")) {
+			// batched events are flushed as a list
+			var batch []uploadedEvent
+			assert.NoError(t, json.Unmarshal(body, &batch))
+			for _, event := range batch {
+				events <- event
+			}
+			return
+		}
+		var event uploadedEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		events <- event
+	}))
+	t.Cleanup(server.Close)
+
+	options.HTTPClient = server.Client()
+	options.Polling = true
+	options.APIKey = "API_KEY"
+	options.URL = server.URL
+	client, err := molasses.Init(options)
+	assert.NoError(t, err)
+	t.Cleanup(client.Stop)
+	return client, events
+}
+
+func TestTrackMetric(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{}, eventTagsPayload)
+	user := molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "team"}}
+
+	client.TrackMetric("revenue", user, 0, nil)
+	event := <-events
+	assert.Equal(t, "revenue", event.Event)
+	if assert.NotNil(t, event.Value) {
+		assert.Equal(t, 0.0, *event.Value)
+	}
+	assert.Nil(t, event.Aggregate)
+
+	client.Track("Checkout Started", user, nil)
+	assert.Nil(t, (<-events).Value)
+}
+
+func TestTrackMetricRejectsValuesThatAreNotFinite(t *testing.T) {
+	errs := &errorRecorder{}
+	client, events := newEventsClient(t, molasses.ClientOptions{OnError: errs.record}, eventTagsPayload)
+	user := molasses.User{ID: "1234"}
+
+	client.TrackMetric("revenue", user, math.NaN(), nil)
+	client.TrackMetric("revenue", user, math.Inf(1), nil)
+	client.TrackMetric("revenue", user, math.Inf(-1), nil)
+	assert.Equal(t, 3, errs.count(molasses.ErrInvalidMetricValue))
+
+	client.TrackMetric("revenue", user, 12.5, nil)
+	assert.Equal(t, 12.5, *(<-events).Value)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected %s event with value %v", event.Event, *event.Value)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBatchedMetricsAreAggregated(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{
+		BatchEvents:   true,
+		FlushInterval: time.Hour,
+	}, eventTagsPayload)
+	jane := molasses.User{ID: "1234"}
+	john := molasses.User{ID: "5678"}
+
+	client.TrackMetric("revenue", jane, 20, nil)
+	client.TrackMetric("revenue", jane, 5.5, nil)
+	client.TrackMetric("revenue", jane, 34, nil)
+	client.TrackMetric("revenue", john, 12, nil)
+	client.Track("Checkout Started", jane, nil)
+	// metrics with other tags are aggregated apart
+	client.TrackMetric("revenue", jane, 8, map[string]interface{}{"coupon": "SUMMER"})
+	client.TrackMetric("revenue", jane, 2, map[string]interface{}{"coupon": "SUMMER"})
+	select {
+	case event := <-events:
+		t.Fatalf("%s was sent before the queue was flushed", event.Event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// stopping the client flushes the queue
+	client.Stop()
+	received := map[string]uploadedEvent{}
+	for i := 0; i < 4; i++ {
+		event := <-events
+		received[event.Event+" "+event.UserID+" "+fmt.Sprint(event.Tags["coupon"])] = event
+	}
+	janeRevenue := received["revenue 1234 <nil>"]
+	if assert.NotNil(t, janeRevenue.Aggregate) {
+		assert.Equal(t, 3, janeRevenue.Aggregate.Count)
+		assert.Equal(t, 59.5, *janeRevenue.Value)
+		assert.Equal(t, 59.5, janeRevenue.Aggregate.Sum)
+		assert.Equal(t, 5.5, janeRevenue.Aggregate.Min)
+		assert.Equal(t, 34.0, janeRevenue.Aggregate.Max)
+	}
+	janeCoupon := received["revenue 1234 SUMMER"]
+	if assert.NotNil(t, janeCoupon.Aggregate) {
+		assert.Equal(t, 2, janeCoupon.Aggregate.Count)
+		assert.Equal(t, 10.0, *janeCoupon.Value)
+		assert.Equal(t, map[string]interface{}{"coupon": "SUMMER"}, janeCoupon.Tags)
+	}
+	johnRevenue := received["revenue 5678 <nil>"]
+	if assert.NotNil(t, johnRevenue.Aggregate) {
+		assert.Equal(t, 1, johnRevenue.Aggregate.Count)
+		assert.Equal(t, 12.0, *johnRevenue.Value)
+	}
+	assert.Contains(t, received, "Checkout Started 1234 <nil>")
+}
+
+func TestFlushSendsEventsInBatches(t *testing.T) {
+	var requests, received int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.String() == "/features" {
+			if _, err := rw.Write([]byte(eventTagsPayload)); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		var batch []uploadedEvent
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&batch))
+		assert.LessOrEqual(t, len(batch), 100)
+		atomic.AddInt32(&requests, 1)
+		atomic.AddInt32(&received, int32(len(batch)))
+	}))
+	defer server.Close()
+
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:        "API_KEY",
+		URL:           server.URL,
+		Polling:       true,
+		BatchEvents:   true,
+		FlushInterval: time.Hour,
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 250; i++ {
+		client.Track("Checkout Started", molasses.User{ID: strconv.Itoa(i)}, nil)
+	}
+
+	// Stop waits for the queued events to be sent
+	client.Stop()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(t, int32(250), atomic.LoadInt32(&received))
+}
+
+func TestStopDoesNotWaitForeverForTheFlush(t *testing.T) {
+	blocked := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.String() == "/features" {
+			if _, err := rw.Write([]byte(eventTagsPayload)); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		select {
+		case <-blocked:
+		case <-req.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(blocked)
+
+	errs := &errorRecorder{}
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:        "API_KEY",
+		URL:           server.URL,
+		Polling:       true,
+		BatchEvents:   true,
+		FlushInterval: time.Hour,
+		FlushTimeout:  50 * time.Millisecond,
+		OnError:       errs.record,
+	})
+	assert.NoError(t, err)
+	client.Track("Checkout Started", molasses.User{ID: "1234"}, nil)
+
+	start := time.Now()
+	client.Stop()
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, 1, errs.count(context.DeadlineExceeded))
+}
+
+func TestEventSampling(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{
+		SampleRate:       0.5,
+		EventSampleRates: map[string]float64{"page_view": 0, "purchase": 1},
+	}, eventTagsPayload)
+
+	client.Track("page_view", molasses.User{ID: "1234"}, nil)
+	client.Track("purchase", molasses.User{ID: "1234"}, nil)
+	event := <-events
+	assert.Equal(t, "purchase", event.Event)
+	assert.Equal(t, 1.0, event.SampleRate)
+
+	var sent int
+	for i := 0; i < 100; i++ {
+		client.Track("Checkout Started", molasses.User{ID: strconv.Itoa(i)}, nil)
+	}
+	timeout := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case event := <-events:
+			assert.Equal(t, 0.5, event.SampleRate)
+			sent++
+		case <-timeout:
+			done = true
+		}
+	}
+	assert.InDelta(t, 50, sent, 20)
+}
+
+func TestInvalidSampleRate(t *testing.T) {
+	_, err := molasses.Init(molasses.ClientOptions{APIKey: "API_KEY", Polling: true, SampleRate: 2})
+	assert.Error(t, err)
+}
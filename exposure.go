@@ -0,0 +1,83 @@
+package molasses
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExposureCacheSize = 10000
+	defaultExposureWindow    = time.Hour
+)
+
+type exposureKey struct {
+	userID     string
+	feature    string
+	assignment Assignment
+}
+
+type exposureEntry struct {
+	key  exposureKey
+	seen time.Time
+}
+
+// exposureCache is a least recently used cache of the experiment_started events that were sent,
+// so a user is only reported once per feature and assignment within the window
+type exposureCache struct {
+	mu      sync.Mutex
+	size    int
+	window  time.Duration
+	entries map[exposureKey]*list.Element
+	// order holds the entries with the most recently used at the front
+	order *list.List
+	now   func() time.Time
+}
+
+// newExposureCache returns nil, which never deduplicates, when size is negative
+func newExposureCache(size int, window time.Duration) *exposureCache {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = defaultExposureCacheSize
+	}
+	if window <= 0 {
+		window = defaultExposureWindow
+	}
+	return &exposureCache{
+		size:    size,
+		window:  window,
+		entries: make(map[exposureKey]*list.Element, size),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// exposed records an exposure and reports whether it was already recorded within the window
+func (c *exposureCache) exposed(key exposureKey) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		entry := el.Value.(*exposureEntry)
+		if now.Sub(entry.seen) < c.window {
+			return true
+		}
+		entry.seen = now
+		return false
+	}
+
+	c.entries[key] = c.order.PushFront(&exposureEntry{key: key, seen: now})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*exposureEntry).key)
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package molasses
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExposureCache(t *testing.T) {
+	now := time.Date(2020, 8, 26, 2, 11, 44, 0, time.UTC)
+	c := newExposureCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	jane := exposureKey{"jane", "CHECKOUT", AssignmentExperiment}
+	assert.False(t, c.exposed(jane))
+	assert.True(t, c.exposed(jane))
+	// a new assignment is a new exposure
+	assert.False(t, c.exposed(exposureKey{"jane", "CHECKOUT", AssignmentControl}))
+
+	now = now.Add(time.Minute)
+	assert.False(t, c.exposed(jane), "the window has passed")
+	assert.True(t, c.exposed(jane))
+}
+
+func TestExposureCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newExposureCache(2, time.Hour)
+	first := exposureKey{"1", "CHECKOUT", AssignmentExperiment}
+	second := exposureKey{"2", "CHECKOUT", AssignmentExperiment}
+	third := exposureKey{"3", "CHECKOUT", AssignmentExperiment}
+
+	c.exposed(first)
+	c.exposed(second)
+	c.exposed(first)
+	c.exposed(third)
+	assert.Equal(t, 2, c.order.Len())
+	assert.True(t, c.exposed(first))
+	assert.False(t, c.exposed(second), "second was the least recently used")
+}
+
+func TestExposureCacheDisabled(t *testing.T) {
+	c := newExposureCache(-1, 0)
+	key := exposureKey{"1", "CHECKOUT", AssignmentExperiment}
+	assert.False(t, c.exposed(key))
+	assert.False(t, c.exposed(key))
+}
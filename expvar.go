@@ -0,0 +1,31 @@
+package molasses
+
+import (
+	"expvar"
+	"time"
+)
+
+// ExpvarMetrics - Metrics published with the expvar package, as a map of counters where every duration
+// is a _count and a _sum counter in seconds
+type ExpvarMetrics struct {
+	m *expvar.Map
+}
+
+// NewExpvarMetrics - Publishes the metrics under the name, clients created with the same name share them
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	if m, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &ExpvarMetrics{m: m}
+	}
+	return &ExpvarMetrics{m: expvar.NewMap(name)}
+}
+
+// IncCounter - Adds one to the counter with the name
+func (e *ExpvarMetrics) IncCounter(name string) {
+	e.m.Add(name, 1)
+}
+
+// ObserveDuration - Adds the duration to the sum of the durations with the name and one to their count
+func (e *ExpvarMetrics) ObserveDuration(name string, d time.Duration) {
+	e.m.Add(name+"_count", 1)
+	e.m.AddFloat(name+"_sum", d.Seconds())
+}
@@ -1,11 +1,10 @@
 package molasses
 
 import (
-	"errors"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"hash/crc32"
-	"math"
-	"strconv"
 	"strings"
 
 	"golang.org/x/mod/semver"
@@ -17,15 +16,45 @@ type feature struct {
 	Description string `json:"description"`
 	Version     string `json:"version"`
 	// Variants []Variant        `json:"variants"`
-	Active   bool             `json:"active"`
-	Segments []featureSegment `json:"segments"`
+	Active        bool             `json:"active"`
+	Segments      []featureSegment `json:"segments"`
+	Rules         []rule           `json:"rules"`
+	Prerequisites []prerequisite   `json:"prerequisites"`
+
+	// ClientSideAvailable is set for features that can be sent to browsers and mobile apps
+	ClientSideAvailable bool `json:"clientSideAvailable"`
 }
 
 type userConstraint struct {
-	Operator      operator `json:"operator"`
-	Values        string   `json:"values"`
-	UserParam     string   `json:"userParam"`
-	UserParamType string   `json:"userParamType"`
+	Operator      operator         `json:"operator"`
+	Values        constraintValues `json:"values"`
+	UserParam     string           `json:"userParam"`
+	UserParamType string           `json:"userParamType"`
+}
+
+// constraintValues holds the comma separated values of a constraint. Values sent as a JSON number or
+// boolean, such as "values": 1235, are kept as they were written.
+type constraintValues string
+
+func (v *constraintValues) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = constraintValues(s)
+		return nil
+	}
+	var literal interface{}
+	if err := json.Unmarshal(data, &literal); err != nil {
+		return err
+	}
+	switch literal.(type) {
+	case nil:
+		*v = ""
+	case float64, bool:
+		*v = constraintValues(bytes.TrimSpace(data))
+	default:
+		return fmt.Errorf("constraint values must be a string, number or boolean, not %s", data)
+	}
+	return nil
 }
 
 type featureSegment struct {
@@ -33,6 +62,10 @@ type featureSegment struct {
 	UserConstraints []userConstraint `json:"userConstraints"`
 	Percentage      int              `json:"percentage"`
 	Constraint      operator         `json:"constraint"`
+	// SegmentID references a named segment whose constraints are used instead of UserConstraints
+	SegmentID string `json:"segmentId"`
+	// Constraints is a tree of constraints that is used instead of UserConstraints when it is set
+	Constraints *constraintNode `json:"constraints"`
 }
 
 type segmentType string
@@ -57,207 +90,239 @@ var (
 	doesNotEqual   operator = "doesNotEqual"
 	contains       operator = "contains"
 	doesNotContain operator = "doesNotContain"
+	exists         operator = "exists"
+	notExists      operator = "notExists"
+	inSegment      operator = "segment"
+	inRange        operator = "range"
 )
 
-func containsParamValue(listAsString string, a string) bool {
-	list := strings.Split(listAsString, ",")
-	for _, b := range list {
-		if b == a {
-			return true
-		}
-	}
-	return false
+// EvaluationReason - Why a feature evaluated the way it did
+type EvaluationReason string
+
+var (
+	ReasonFeatureNotFound  EvaluationReason = "FEATURE_NOT_FOUND"   // ReasonFeatureNotFound - the feature is not set in the environment
+	ReasonFeatureInactive  EvaluationReason = "FEATURE_INACTIVE"    // ReasonFeatureInactive - the feature is turned off
+	ReasonNoUser           EvaluationReason = "NO_USER"             // ReasonNoUser - no user was passed so the feature is active for everyone
+	ReasonAlwaysControl    EvaluationReason = "ALWAYS_CONTROL"      // ReasonAlwaysControl - the user is in the alwaysControl segment
+	ReasonAlwaysExperiment EvaluationReason = "ALWAYS_EXPERIMENT"   // ReasonAlwaysExperiment - the user is in the alwaysExperiment segment
+	ReasonPercentage       EvaluationReason = "PERCENTAGE_ROLLOUT"  // ReasonPercentage - the user was placed by the everyoneElse percentage
+	ReasonPrerequisiteFail EvaluationReason = "PREREQUISITE_FAILED" // ReasonPrerequisiteFail - a prerequisite feature is not active for the user
+	ReasonRuleMatch        EvaluationReason = "RULE_MATCH"          // ReasonRuleMatch - the user matched one of the feature's rules
+	ReasonFallthrough      EvaluationReason = "FALLTHROUGH"         // ReasonFallthrough - the user did not match any of the feature's rules
+)
+
+// EvaluationDetail - The result of evaluating a feature for a user and why it was reached
+type EvaluationDetail struct {
+	Key    string
+	Active bool
+	Reason EvaluationReason
+	// Assignment is the group or named variant the user was given, AssignmentControl whenever
+	// the feature is not active for them
+	Assignment Assignment
+	// RuleID is the ID of the rule that decided the result when Reason is ReasonRuleMatch
+	RuleID string
+	// FailedPrerequisite is the key of the prerequisite feature that was not active when Reason is ReasonPrerequisiteFail
+	FailedPrerequisite string
+	// Errors lists the problems found during evaluation. With StrictTypes enabled it holds a
+	// *TypeMismatchError for every user param that could not be used as its constraint's type.
+	Errors []error
+}
+
+// evaluator is the compiled and immutable set of features and segments from a payload
+type evaluator struct {
+	features map[string]*compiledFeature
+	segments map[string]*compiledSegment
+	// raw holds the features and segments as they were received so the next payload can be merged into them
+	raw         map[string]feature
+	rawSegments map[string]userSegment
+	strict      bool
 }
 
-// User - The representation of your user
-type User struct {
-	ID     string
-	Params map[string]interface{}
+// evaluation holds the state of evaluating a single feature, and its prerequisites, for a single user
+type evaluation struct {
+	*evaluator
+	user   *User
+	errors []error
+	// segmentResults memoizes whether the user is in a named segment, indexed by the segment's index
+	segmentResults *[]segmentResult
 }
 
-func isActive(f feature, user *User) bool {
+func (ev *evaluator) evaluate(f *compiledFeature, user *User) EvaluationDetail {
+	e := evaluation{evaluator: ev, user: user}
+	detail := e.evaluate(f)
+	detail.Errors = e.errors
+	e.releaseSegmentResults()
+	return detail
+}
+
+func (e *evaluation) evaluate(f *compiledFeature) EvaluationDetail {
+	detail := EvaluationDetail{Key: f.Key, Assignment: AssignmentControl}
 	if !f.Active {
-		return false
+		detail.Reason = ReasonFeatureInactive
+		return detail
+	}
+	if key, ok := e.prerequisitesMet(f); !ok {
+		detail.Reason = ReasonPrerequisiteFail
+		detail.FailedPrerequisite = key
+		return detail
 	}
 	// if there is no user just return true
-	if user == nil {
-		return true
+	if e.user == nil {
+		detail.Active = true
+		detail.Assignment = AssignmentExperiment
+		detail.Reason = ReasonNoUser
+		return detail
 	}
 
-	// Build a config map:
-	segmentMap := map[string]featureSegment{}
-	for _, s := range f.Segments {
-		switch s.SegmentType {
-		case alwaysControl:
-
-			segmentMap["alwaysControl"] = s
-			continue
-		case alwaysExperiment:
-			segmentMap["alwaysExperiment"] = s
-			continue
-		case everyoneElse:
-			segmentMap["everyoneElse"] = s
-			continue
-		}
+	if r := e.matchRule(f.rules); r != nil {
+		detail.Assignment = r.variant
+		detail.Active = detail.Assignment != AssignmentControl
+		detail.Reason = r.reason
+		detail.RuleID = r.id
+		return detail
 	}
-	// check if they should have the control always
-	if alwaysControlSegment, ok := segmentMap["alwaysControl"]; ok && isUserInSegment(*user, alwaysControlSegment) {
-		return false
-	}
-	// check if they should have the experiment always
-	if alwaysExperimentSegment, ok := segmentMap["alwaysExperiment"]; ok && isUserInSegment(*user, alwaysExperimentSegment) {
-		return true
+	detail.Reason = f.fallthroughReason
+	return detail
+}
+
+// matchRule returns the first rule the user matches, or nil when none of them do
+func (e *evaluation) matchRule(rules []compiledRule) *compiledRule {
+	for i := range rules {
+		if e.isUserInRule(&rules[i]) {
+			return &rules[i]
+		}
 	}
+	return nil
+}
 
-	return getUserPercentage(*user, segmentMap["everyoneElse"])
+var crcTable = crc32.MakeTable(crc32.IEEE)
 
+// userBucket is the CRC-32 checksum of the user's ID modulo 100. It is computed over the string
+// directly so that bucketing a user does not allocate.
+func userBucket(id string) uint32 {
+	crc := ^uint32(0)
+	for i := 0; i < len(id); i++ {
+		crc = crcTable[byte(crc)^id[i]] ^ (crc >> 8)
+	}
+	return ^crc % 100
 }
 
-func getUserPercentage(user User, segment featureSegment) bool {
-	if segment.Percentage == 100 {
+func getUserPercentage(user User, percentage int) bool {
+	if percentage >= 100 {
 		return true
 	}
+	if percentage <= 0 {
+		return false
+	}
 
-	c := float64(crc32.ChecksumIEEE([]byte(user.ID)))
-	v := math.Abs(math.Mod(c, 100.0))
-
-	return v < float64(segment.Percentage)
+	return userBucket(user.ID) < uint32(percentage)
 }
 
-func isUserInSegment(user User, s featureSegment) bool {
-	constraintsToBeMet := len(s.UserConstraints)
-	if s.Constraint == any {
-		constraintsToBeMet = 1
+func (e *evaluation) meetsConstraint(constraint *compiledConstraint) bool {
+	user := e.user
+	if constraint.Operator == inSegment {
+		return e.isUserInAnyNamedSegment(constraint.segmentIDs)
+	}
+	userValue, paramExists := user.Params[constraint.UserParam]
+	if constraint.UserParam == "id" {
+		paramExists = true
+		userValue = user.ID
 	}
-	constraintsMet := 0
-	for i := 0; i < len(s.UserConstraints); i++ {
-		constraint := s.UserConstraints[i]
-		userValue, paramExists := user.Params[constraint.UserParam]
-		if constraint.UserParam == "id" {
-			paramExists = true
-			userValue = user.ID
+	// A param only exists when it has a value, so a nil entry is treated the same as a missing one.
+	// Every comparison operator, including nin, doesNotEqual and doesNotContain, requires the param to
+	// exist; use notExists to target users who have not set it.
+	if userValue == nil {
+		paramExists = false
+	}
+	switch constraint.Operator {
+	case exists:
+		return paramExists
+	case notExists:
+		return !paramExists
+	}
+	if !paramExists || !constraint.valid {
+		return false
+	}
+	// the user's ID is always a string so it is converted leniently even in strict mode
+	strict := e.strict && constraint.UserParam != "id"
+	switch constraint.UserParamType {
+	case "semver":
+		v, err := toString(userValue, strict)
+		if err != nil {
+			e.typeMismatch(constraint, userValue)
+			return false
 		}
-		switch constraint.UserParamType {
-		case "semver":
-			v, err := getStringValue(userValue)
-			if err != nil {
-				continue
-			}
-			if meetsConstraintForSemVer(v, paramExists, constraint) {
-				constraintsMet = constraintsMet + 1
-			}
-		case "number":
-			v, err := getFloat64Value(userValue)
-			if err != nil {
-				continue
-			}
-			if meetsConstraintForNumber(v, paramExists, constraint) {
-				constraintsMet = constraintsMet + 1
-			}
-		case "bool":
-			v, err := getBoolValue(userValue)
-			if err != nil {
-				continue
-			}
-			if meetsConstraintForBool(v, paramExists, constraint) {
-				constraintsMet = constraintsMet + 1
-			}
-		default:
-			v, err := getStringValue(userValue)
-			if err != nil {
-				continue
-			}
-			if meetsConstraintForString(v, paramExists, constraint) {
-				constraintsMet = constraintsMet + 1
-			}
-
+		return meetsConstraintForSemVer(v, paramExists, constraint)
+	case "number":
+		v, err := toFloat64(userValue, strict)
+		if err != nil {
+			e.typeMismatch(constraint, userValue)
+			return false
 		}
-
-	}
-	return constraintsMet >= constraintsToBeMet
-}
-
-func getFloat64Value(value interface{}) (float64, error) {
-	switch v := value.(type) {
-	case bool:
-		return 0.0, errors.New("not valid value")
-	case string:
-		return strconv.ParseFloat(v, 10)
-	case uint:
-	case int:
-		return float64(v), nil
-	case float64:
-		return v, nil
+		return meetsConstraintForNumber(v, paramExists, constraint)
+	case "bool":
+		v, err := toBool(userValue, strict)
+		if err != nil {
+			e.typeMismatch(constraint, userValue)
+			return false
+		}
+		return meetsConstraintForBool(v, paramExists, constraint)
+	default:
+		if values, ok := userValue.([]string); ok {
+			return meetsConstraintForStrings(values, paramExists, constraint)
+		}
+		v, err := toString(userValue, strict)
+		if err != nil {
+			e.typeMismatch(constraint, userValue)
+			return false
+		}
+		return meetsConstraintForString(v, paramExists, constraint)
 	}
-	return 0.0, errors.New("not valid value")
 }
 
-func getBoolValue(value interface{}) (bool, error) {
-	switch v := value.(type) {
-	case bool:
-		return v, nil
-	case string:
-		return strconv.ParseBool(v)
-	case int:
-	case float64:
-		return v > 0.0, nil
+// typeMismatch records a user param that could not be converted, it is only surfaced in strict mode
+func (e *evaluation) typeMismatch(constraint *compiledConstraint, value interface{}) {
+	if !e.strict {
+		return
 	}
-	return false, errors.New("not valid value")
-}
-
-func getStringValue(value interface{}) (string, error) {
-	switch v := value.(type) {
-	case bool:
-		return strconv.FormatBool(v), nil
-	case string:
-		return v, nil
-	case int:
-	case float64:
-		return fmt.Sprintf("%v", v), nil
+	expected := constraint.UserParamType
+	if expected == "" {
+		expected = "string"
 	}
-	return "", errors.New("not valid value")
+	e.errors = append(e.errors, &TypeMismatchError{Param: constraint.UserParam, Expected: expected, Value: value})
 }
 
-func meetsConstraintForSemVer(v string, paramExists bool, constraint userConstraint) bool {
+func meetsConstraintForSemVer(v string, paramExists bool, constraint *compiledConstraint) bool {
 	if !paramExists {
 		return false
 	}
-	comparison := semver.Compare(v, constraint.Values)
+	v, ok := normalizeVersion(v)
+	if !ok {
+		return false
+	}
 	switch constraint.Operator {
-	case equals:
-		if comparison == 0 {
-			return true
-		}
-	case doesNotEqual:
-		if comparison != 0 {
-			return true
-		}
-	case gt:
-		if comparison > 0 {
-			return true
-		}
-	case lt:
-		if comparison < 0 {
-			return true
-		}
-	case gte:
-		if comparison >= 0 {
-			return true
+	case in:
+		for _, version := range constraint.versions {
+			if semver.Compare(v, version) == 0 {
+				return true
+			}
 		}
-	case lte:
-		if comparison <= 0 {
-			return true
+		return false
+	case nin:
+		for _, version := range constraint.versions {
+			if semver.Compare(v, version) == 0 {
+				return false
+			}
 		}
-
+		return true
+	case inRange:
+		return constraint.versionRange.contains(v)
 	}
-	return false
+	return compareVersions(constraint.Operator, semver.Compare(v, constraint.versions[0]))
 }
 
-func meetsConstraintForBool(userValue bool, paramExists bool, constraint userConstraint) bool {
-	values, err := strconv.ParseBool(constraint.Values)
-	if err != nil {
-		return false
-	}
+func meetsConstraintForBool(userValue bool, paramExists bool, constraint *compiledConstraint) bool {
+	values := constraint.boolean
 	switch constraint.Operator {
 	case equals:
 		if paramExists && userValue == values {
@@ -273,11 +338,8 @@ func meetsConstraintForBool(userValue bool, paramExists bool, constraint userCon
 	return false
 }
 
-func meetsConstraintForNumber(userValue float64, paramExists bool, constraint userConstraint) bool {
-	values, err := strconv.ParseFloat(constraint.Values, 10)
-	if err != nil {
-		return false
-	}
+func meetsConstraintForNumber(userValue float64, paramExists bool, constraint *compiledConstraint) bool {
+	values := constraint.number
 	switch constraint.Operator {
 	case equals:
 		if paramExists && userValue == values {
@@ -309,30 +371,30 @@ func meetsConstraintForNumber(userValue float64, paramExists bool, constraint us
 	return false
 }
 
-func meetsConstraintForString(userValue string, paramExists bool, constraint userConstraint) bool {
+func meetsConstraintForString(userValue string, paramExists bool, constraint *compiledConstraint) bool {
 	switch constraint.Operator {
 	case in:
-		if paramExists && containsParamValue(constraint.Values, userValue) {
+		if _, ok := constraint.values[userValue]; paramExists && ok {
 			return true
 		}
 	case nin:
-		if paramExists && !containsParamValue(constraint.Values, userValue) {
+		if _, ok := constraint.values[userValue]; paramExists && !ok {
 			return true
 		}
 	case equals:
-		if paramExists && userValue == constraint.Values {
+		if paramExists && userValue == string(constraint.Values) {
 			return true
 		}
 	case doesNotEqual:
-		if paramExists && userValue != constraint.Values {
+		if paramExists && userValue != string(constraint.Values) {
 			return true
 		}
 	case contains:
-		if paramExists && strings.Contains(userValue, constraint.Values) {
+		if paramExists && strings.Contains(userValue, string(constraint.Values)) {
 			return true
 		}
 	case doesNotContain:
-		if paramExists && !strings.Contains(userValue, constraint.Values) {
+		if paramExists && !strings.Contains(userValue, string(constraint.Values)) {
 			return true
 		}
 	default:
@@ -340,3 +402,19 @@ func meetsConstraintForString(userValue string, paramExists bool, constraint use
 	}
 	return false
 }
+
+// meetsConstraintForStrings checks a list of values, negated operators such as nin match when none
+// of the values match the operator they negate and the other operators match when any value does
+func meetsConstraintForStrings(userValues []string, paramExists bool, constraint *compiledConstraint) bool {
+	negated := constraint.Operator == nin || constraint.Operator == doesNotEqual || constraint.Operator == doesNotContain
+	for _, v := range userValues {
+		met := meetsConstraintForString(v, paramExists, constraint)
+		if negated && !met {
+			return false
+		}
+		if !negated && met {
+			return true
+		}
+	}
+	return negated && paramExists
+}
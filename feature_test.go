@@ -0,0 +1,682 @@
+package molasses
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func isUserInSegment(user User, s featureSegment) bool {
+	e := evaluation{evaluator: &evaluator{}, user: &user}
+	n := (&compiler{}).compileTreeOrConstraints(s.Constraints, s.UserConstraints, s.Constraint)
+	return e.meetsNode(&n)
+}
+
+// testEvaluator compiles features and segments the same way they are when a payload is loaded
+func testEvaluator(segments map[string]userSegment, strict bool, features ...feature) *evaluator {
+	raw := map[string]feature{}
+	for _, f := range features {
+		raw[f.Key] = f
+	}
+	ev, _ := newEvaluator(raw, segments, strict)
+	return ev
+}
+
+func evaluateFeature(f feature, user *User) EvaluationDetail {
+	ev := testEvaluator(nil, false, f)
+	return ev.evaluate(ev.features[f.Key], user)
+}
+
+func TestExistenceOperators(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint userConstraint
+		params     map[string]interface{}
+		expected   bool
+	}{
+		{"exists when set", userConstraint{Operator: exists, UserParam: "teamId"}, map[string]interface{}{"teamId": "123"}, true},
+		{"exists when missing", userConstraint{Operator: exists, UserParam: "teamId"}, map[string]interface{}{}, false},
+		{"exists when nil", userConstraint{Operator: exists, UserParam: "teamId"}, map[string]interface{}{"teamId": nil}, false},
+		{"exists when params are nil", userConstraint{Operator: exists, UserParam: "teamId"}, nil, false},
+		{"exists with a bool type", userConstraint{Operator: exists, UserParam: "betaOptIn", UserParamType: "bool"}, map[string]interface{}{"betaOptIn": false}, true},
+		{"exists with a number type", userConstraint{Operator: exists, UserParam: "seats", UserParamType: "number"}, map[string]interface{}{"seats": 0}, true},
+		{"exists with a semver type", userConstraint{Operator: exists, UserParam: "version", UserParamType: "semver"}, map[string]interface{}{"version": "v1.0.0"}, true},
+		{"notExists when set", userConstraint{Operator: notExists, UserParam: "teamId"}, map[string]interface{}{"teamId": "123"}, false},
+		{"notExists when missing", userConstraint{Operator: notExists, UserParam: "teamId"}, map[string]interface{}{}, true},
+		{"notExists when nil", userConstraint{Operator: notExists, UserParam: "teamId"}, map[string]interface{}{"teamId": nil}, true},
+		{"notExists with a number type", userConstraint{Operator: notExists, UserParam: "seats", UserParamType: "number"}, map[string]interface{}{}, true},
+		{"notExists for id", userConstraint{Operator: notExists, UserParam: "id"}, nil, false},
+		{"nin when missing", userConstraint{Operator: nin, Values: "a,b", UserParam: "teamId"}, map[string]interface{}{}, false},
+		{"nin when set", userConstraint{Operator: nin, Values: "a,b", UserParam: "teamId"}, map[string]interface{}{"teamId": "c"}, true},
+		{"doesNotEqual when missing", userConstraint{Operator: doesNotEqual, Values: "a", UserParam: "teamId"}, map[string]interface{}{}, false},
+		{"doesNotEqual number when missing", userConstraint{Operator: doesNotEqual, Values: "1", UserParam: "seats", UserParamType: "number"}, map[string]interface{}{}, false},
+		{"doesNotEqual bool when missing", userConstraint{Operator: doesNotEqual, Values: "true", UserParam: "betaOptIn", UserParamType: "bool"}, map[string]interface{}{}, false},
+		{"doesNotContain when missing", userConstraint{Operator: doesNotContain, Values: "a", UserParam: "teamId"}, map[string]interface{}{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user := User{ID: "1234", Params: test.params}
+			segment := featureSegment{UserConstraints: []userConstraint{test.constraint}}
+			assert.Equal(t, test.expected, isUserInSegment(user, segment))
+		})
+	}
+}
+
+func TestNotExistsCombinedWithAny(t *testing.T) {
+	segment := featureSegment{
+		Constraint: any,
+		UserConstraints: []userConstraint{
+			{Operator: notExists, UserParam: "teamId"},
+			{Operator: nin, Values: "a,b", UserParam: "teamId"},
+		},
+	}
+	assert.True(t, isUserInSegment(User{ID: "1"}, segment))
+	assert.True(t, isUserInSegment(User{ID: "1", Params: map[string]interface{}{"teamId": "c"}}, segment))
+	assert.False(t, isUserInSegment(User{ID: "1", Params: map[string]interface{}{"teamId": "a"}}, segment))
+}
+
+type seats int
+
+func TestToFloat64(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		strict   bool
+		expected float64
+		valid    bool
+	}{
+		{"int", 5, false, 5, true},
+		{"int8", int8(-5), false, -5, true},
+		{"int32", int32(5), false, 5, true},
+		{"int64", int64(5), false, 5, true},
+		{"uint", uint(5), false, 5, true},
+		{"uint64", uint64(5), false, 5, true},
+		{"float32", float32(1.5), false, 1.5, true},
+		{"float64", 1.5, false, 1.5, true},
+		{"json.Number", json.Number("14588.007"), false, 14588.007, true},
+		{"invalid json.Number", json.Number("abc"), false, 0, false},
+		{"time.Duration", 90 * time.Second, false, 90, true},
+		{"named int", seats(12), true, 12, true},
+		{"string", "12.5", false, 12.5, true},
+		{"string when strict", "12.5", true, 0, false},
+		{"invalid string", "abc", false, 0, false},
+		{"bool", true, false, 0, false},
+		{"nil", nil, false, 0, false},
+		{"slice", []int{1}, false, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := toFloat64(test.value, test.strict)
+			assert.Equal(t, test.valid, err == nil)
+			assert.Equal(t, test.expected, v)
+		})
+	}
+}
+
+func TestToBool(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		strict   bool
+		expected bool
+		valid    bool
+	}{
+		{"bool", true, true, true, true},
+		{"string", "true", false, true, true},
+		{"string when strict", "true", true, false, false},
+		{"int", 1, false, true, true},
+		{"zero int", 0, false, false, true},
+		{"int64", int64(3), false, true, true},
+		{"float64", 0.5, false, true, true},
+		{"int when strict", 1, true, false, false},
+		{"invalid string", "nope", false, false, false},
+		{"nil", nil, false, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := toBool(test.value, test.strict)
+			assert.Equal(t, test.valid, err == nil)
+			assert.Equal(t, test.expected, v)
+		})
+	}
+}
+
+func TestToString(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		strict   bool
+		expected string
+		valid    bool
+	}{
+		{"string", "foo", true, "foo", true},
+		{"bool", true, false, "true", true},
+		{"int", 1235, false, "1235", true},
+		{"int64", int64(-7), false, "-7", true},
+		{"uint32", uint32(7), false, "7", true},
+		{"float64", 1000000.5, false, "1000000.5", true},
+		{"float32", float32(1.25), false, "1.25", true},
+		{"json.Number", json.Number("42"), false, "42", true},
+		{"json.Number when strict", json.Number("42"), true, "", false},
+		{"int when strict", 1235, true, "", false},
+		{"nil", nil, false, "", false},
+		{"map", map[string]string{}, false, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := toString(test.value, test.strict)
+			assert.Equal(t, test.valid, err == nil)
+			assert.Equal(t, test.expected, v)
+		})
+	}
+}
+
+func TestStrictTypesReportsMismatches(t *testing.T) {
+	f := feature{
+		Key:    "STRICT",
+		Active: true,
+		Segments: []featureSegment{
+			{
+				SegmentType: alwaysExperiment,
+				Constraint:  any,
+				UserConstraints: []userConstraint{
+					{Operator: gte, Values: "10", UserParam: "seats", UserParamType: "number"},
+					{Operator: equals, Values: "true", UserParam: "beta", UserParamType: "bool"},
+				},
+			},
+			{SegmentType: everyoneElse, Percentage: 0},
+		},
+	}
+	user := User{ID: "1", Params: map[string]interface{}{"seats": "50", "beta": "true"}}
+
+	lenient := evaluateFeature(f, &user)
+	assert.True(t, lenient.Active)
+	assert.Equal(t, ReasonAlwaysExperiment, lenient.Reason)
+	assert.Empty(t, lenient.Errors)
+
+	ev := testEvaluator(nil, true, f)
+	strict := ev.evaluate(ev.features[f.Key], &user)
+	assert.False(t, strict.Active)
+	assert.Equal(t, ReasonPercentage, strict.Reason)
+	if assert.Len(t, strict.Errors, 2) {
+		mismatch, ok := strict.Errors[0].(*TypeMismatchError)
+		assert.True(t, ok)
+		assert.Equal(t, "seats", mismatch.Param)
+		assert.Equal(t, "number", mismatch.Expected)
+	}
+
+	user.Params = map[string]interface{}{"seats": int64(50)}
+	strict = ev.evaluate(ev.features[f.Key], &user)
+	assert.True(t, strict.Active)
+	assert.Empty(t, strict.Errors)
+}
+
+func TestPrerequisites(t *testing.T) {
+	everyone := []featureSegment{{SegmentType: everyoneElse, Percentage: 100}}
+	betaOnly := []featureSegment{
+		{
+			SegmentType:     alwaysExperiment,
+			UserConstraints: []userConstraint{{Operator: equals, Values: "true", UserParam: "beta", UserParamType: "bool"}},
+		},
+	}
+	features := map[string]feature{
+		"PAYMENTS_V2":  {Key: "PAYMENTS_V2", Active: true, Segments: betaOnly},
+		"NEW_CHECKOUT": {Key: "NEW_CHECKOUT", Active: true, Segments: everyone, Prerequisites: []prerequisite{{Key: "PAYMENTS_V2"}}},
+		"EXPRESS_PAY":  {Key: "EXPRESS_PAY", Active: true, Segments: everyone, Prerequisites: []prerequisite{{Key: "NEW_CHECKOUT"}}},
+		"ORPHAN":       {Key: "ORPHAN", Active: true, Segments: everyone, Prerequisites: []prerequisite{{Key: "MISSING"}}},
+		"CYCLE_A":      {Key: "CYCLE_A", Active: true, Segments: everyone, Prerequisites: []prerequisite{{Key: "CYCLE_B"}}},
+		"CYCLE_B":      {Key: "CYCLE_B", Active: true, Segments: everyone, Prerequisites: []prerequisite{{Key: "CYCLE_A"}}},
+		"AFTER_CYCLE":  {Key: "AFTER_CYCLE", Active: true, Segments: everyone, Prerequisites: []prerequisite{{Key: "CYCLE_A"}}},
+	}
+	cycles := findPrerequisiteCycles(features)
+	assert.Equal(t, map[string]bool{"CYCLE_A": true, "CYCLE_B": true}, cycles)
+
+	ev, errs := newEvaluator(features, nil, false)
+	assert.Len(t, errs, 2)
+	beta := User{ID: "1", Params: map[string]interface{}{"beta": true}}
+	other := User{ID: "2", Params: map[string]interface{}{"beta": false}}
+
+	assert.True(t, ev.evaluate(ev.features["NEW_CHECKOUT"], &beta).Active)
+	assert.True(t, ev.evaluate(ev.features["EXPRESS_PAY"], &beta).Active)
+
+	detail := ev.evaluate(ev.features["NEW_CHECKOUT"], &other)
+	assert.False(t, detail.Active)
+	assert.Equal(t, ReasonPrerequisiteFail, detail.Reason)
+	assert.Equal(t, "PAYMENTS_V2", detail.FailedPrerequisite)
+
+	detail = ev.evaluate(ev.features["EXPRESS_PAY"], &other)
+	assert.False(t, detail.Active)
+	assert.Equal(t, "NEW_CHECKOUT", detail.FailedPrerequisite)
+
+	detail = ev.evaluate(ev.features["ORPHAN"], &beta)
+	assert.False(t, detail.Active)
+	assert.Equal(t, "MISSING", detail.FailedPrerequisite)
+
+	detail = ev.evaluate(ev.features["CYCLE_A"], &beta)
+	assert.False(t, detail.Active)
+	assert.Equal(t, ReasonPrerequisiteFail, detail.Reason)
+	assert.NotEmpty(t, detail.Errors)
+
+	detail = ev.evaluate(ev.features["AFTER_CYCLE"], nil)
+	assert.False(t, detail.Active)
+	assert.Equal(t, "CYCLE_A", detail.FailedPrerequisite)
+}
+
+func TestNamedSegments(t *testing.T) {
+	segments := map[string]userSegment{
+		"employees": {
+			ID:              "employees",
+			UserConstraints: []userConstraint{{Operator: contains, Values: "@molasses.app", UserParam: "email"}},
+		},
+		"eu": {
+			ID:              "eu",
+			Constraint:      any,
+			UserConstraints: []userConstraint{{Operator: in, Values: "DE,FR,NL", UserParam: "country"}},
+		},
+		"eu-employees": {
+			ID: "eu-employees",
+			UserConstraints: []userConstraint{
+				{Operator: inSegment, Values: "employees"},
+				{Operator: inSegment, Values: "eu"},
+			},
+		},
+		"self": {
+			ID:              "self",
+			UserConstraints: []userConstraint{{Operator: inSegment, Values: "self"}},
+		},
+	}
+	f := feature{
+		Key:    "EU_BETA",
+		Active: true,
+		Segments: []featureSegment{
+			{SegmentType: alwaysControl, SegmentID: "self"},
+			{SegmentType: alwaysExperiment, SegmentID: "eu-employees"},
+			{SegmentType: everyoneElse, Percentage: 0},
+		},
+	}
+	ev := testEvaluator(segments, false, f)
+
+	user := User{ID: "1", Params: map[string]interface{}{"email": "jane@molasses.app", "country": "FR"}}
+	detail := ev.evaluate(ev.features[f.Key], &user)
+	assert.True(t, detail.Active)
+	assert.Equal(t, ReasonAlwaysExperiment, detail.Reason)
+
+	user.Params["country"] = "US"
+	assert.False(t, ev.evaluate(ev.features[f.Key], &user).Active)
+
+	e := evaluation{evaluator: ev, user: &user}
+	assert.True(t, e.isUserInAnyNamedSegment([]string{"eu", "employees"}))
+	results := *e.segmentResults
+	assert.Equal(t, segmentNotMatched, results[ev.segments["eu"].index])
+	assert.Equal(t, segmentMatched, results[ev.segments["employees"].index])
+	assert.Equal(t, segmentUnknown, results[ev.segments["self"].index])
+	e.releaseSegmentResults()
+
+	f.Segments = []featureSegment{{SegmentType: alwaysExperiment, SegmentID: "missing"}}
+	ev = testEvaluator(segments, false, f)
+	detail = ev.evaluate(ev.features[f.Key], &user)
+	assert.False(t, detail.Active)
+	assert.NotEmpty(t, detail.Errors)
+}
+
+func TestOrderedRules(t *testing.T) {
+	fifty := 50
+	f := feature{
+		Key:    "CHECKOUT",
+		Active: true,
+		Rules: []rule{
+			{
+				ID:              "blocked",
+				UserConstraints: []userConstraint{{Operator: in, Values: "CN", UserParam: "country"}},
+				Variant:         AssignmentControl,
+			},
+			{
+				ID:              "enterprise",
+				UserConstraints: []userConstraint{{Operator: equals, Values: "enterprise", UserParam: "plan"}},
+				Variant:         "one-page",
+			},
+			{
+				ID:              "enterprise-again",
+				UserConstraints: []userConstraint{{Operator: equals, Values: "enterprise", UserParam: "plan"}},
+				Variant:         "never-reached",
+			},
+			{ID: "half", Percentage: &fifty},
+		},
+	}
+	tests := []struct {
+		name       string
+		user       User
+		active     bool
+		reason     EvaluationReason
+		ruleID     string
+		assignment Assignment
+	}{
+		{"first rule wins", User{ID: "1", Params: map[string]interface{}{"country": "CN", "plan": "enterprise"}}, false, ReasonRuleMatch, "blocked", "control"},
+		{"later rule with a variant", User{ID: "1", Params: map[string]interface{}{"plan": "enterprise"}}, true, ReasonRuleMatch, "enterprise", "one-page"},
+		{"percentage rule in rollout", User{ID: "2"}, true, ReasonRuleMatch, "half", "experiment"},
+		{"percentage rule outside rollout", User{ID: "1"}, false, ReasonFallthrough, "", "control"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detail := evaluateFeature(f, &test.user)
+			assert.Equal(t, test.active, detail.Active)
+			assert.Equal(t, test.reason, detail.Reason)
+			assert.Equal(t, test.ruleID, detail.RuleID)
+			assert.Equal(t, test.assignment, detail.Assignment)
+		})
+	}
+}
+
+func TestLegacySegmentsBecomeRules(t *testing.T) {
+	f := feature{
+		Key:    "LEGACY",
+		Active: true,
+		Segments: []featureSegment{
+			{SegmentType: everyoneElse, Percentage: 100},
+			{
+				SegmentType:     alwaysExperiment,
+				UserConstraints: []userConstraint{{Operator: equals, Values: "a", UserParam: "group"}},
+			},
+			{
+				SegmentType:     alwaysControl,
+				UserConstraints: []userConstraint{{Operator: equals, Values: "b", UserParam: "group"}},
+			},
+			{
+				SegmentType:     alwaysControl,
+				UserConstraints: []userConstraint{{Operator: equals, Values: "a", UserParam: "group"}},
+			},
+			{SegmentType: everyoneElse, Percentage: 0},
+		},
+	}
+	rules, reason := f.targetingRules()
+	assert.Equal(t, ReasonPercentage, reason)
+	assert.Len(t, rules, 4)
+
+	// duplicate alwaysControl segments are all honoured, and they win over alwaysExperiment
+	for _, group := range []string{"a", "b"} {
+		detail := evaluateFeature(f, &User{ID: "1", Params: map[string]interface{}{"group": group}})
+		assert.False(t, detail.Active)
+		assert.Equal(t, ReasonAlwaysControl, detail.Reason)
+	}
+	// the first everyoneElse segment is used
+	detail := evaluateFeature(f, &User{ID: "1"})
+	assert.True(t, detail.Active)
+	assert.Equal(t, ReasonPercentage, detail.Reason)
+}
+
+func TestConstraintTree(t *testing.T) {
+	payload := `{
+		"id": "enterprise",
+		"variant": "experiment",
+		"constraints": {
+			"all": [
+				{
+					"any": [
+						{"operator": "equals", "values": "enterprise", "userParam": "plan", "userParamType": ""},
+						{"operator": "gt", "values": "50", "userParam": "seats", "userParamType": "number"}
+					]
+				},
+				{"not": {"operator": "in", "values": "CN", "userParam": "country", "userParamType": ""}}
+			]
+		}
+	}`
+	var r rule
+	assert.NoError(t, json.NewDecoder(strings.NewReader(payload)).Decode(&r))
+	f := feature{Key: "TREE", Active: true, Rules: []rule{r}}
+
+	tests := []struct {
+		name     string
+		params   map[string]interface{}
+		expected bool
+	}{
+		{"enterprise plan", map[string]interface{}{"plan": "enterprise", "country": "US"}, true},
+		{"many seats", map[string]interface{}{"plan": "team", "seats": 51, "country": "US"}, true},
+		{"few seats", map[string]interface{}{"plan": "team", "seats": 5, "country": "US"}, false},
+		{"excluded country", map[string]interface{}{"plan": "enterprise", "country": "CN"}, false},
+		{"no country", map[string]interface{}{"plan": "enterprise"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, evaluateFeature(f, &User{ID: "1", Params: test.params}).Active)
+		})
+	}
+
+	// the flat format is still used when there is no tree
+	flat := feature{Key: "FLAT", Active: true, Rules: []rule{{
+		Constraint: any,
+		UserConstraints: []userConstraint{
+			{Operator: equals, Values: "enterprise", UserParam: "plan"},
+			{Operator: gt, Values: "50", UserParam: "seats", UserParamType: "number"},
+		},
+	}}}
+	assert.True(t, evaluateFeature(flat, &User{ID: "1", Params: map[string]interface{}{"seats": 60}}).Active)
+	assert.False(t, evaluateFeature(flat, &User{ID: "1", Params: map[string]interface{}{"seats": 6}}).Active)
+}
+
+func TestConstraintTreeShortCircuits(t *testing.T) {
+	e := evaluation{evaluator: &evaluator{strict: true}, user: &User{ID: "1", Params: map[string]interface{}{"plan": "enterprise", "seats": "60"}}}
+	tree := &constraintNode{Any: []constraintNode{
+		{userConstraint: userConstraint{Operator: equals, Values: "enterprise", UserParam: "plan"}},
+		{userConstraint: userConstraint{Operator: gt, Values: "50", UserParam: "seats", UserParamType: "number"}},
+	}}
+	n := (&compiler{}).compileNode(tree)
+	assert.True(t, e.meetsNode(&n))
+	// the seats constraint is never checked so its type mismatch is not reported
+	assert.Empty(t, e.errors)
+
+	n = (&compiler{}).compileNode(&constraintNode{All: []constraintNode{}})
+	assert.True(t, e.meetsNode(&n))
+	n = (&compiler{}).compileNode(&constraintNode{Any: []constraintNode{}})
+	assert.False(t, e.meetsNode(&n))
+}
+
+func TestEvaluateDoesNotAllocate(t *testing.T) {
+	segments := map[string]userSegment{
+		"enterprise": {ID: "enterprise", UserConstraints: []userConstraint{{Operator: in, Values: "enterprise,business", UserParam: "plan"}}},
+	}
+	f := feature{
+		Key:    "CHECKOUT",
+		Active: true,
+		Segments: []featureSegment{
+			{
+				SegmentType: alwaysControl,
+				Constraint:  any,
+				UserConstraints: []userConstraint{
+					{Operator: nin, Values: "US,CA,MX", UserParam: "country"},
+					{Operator: equals, Values: "true", UserParam: "blocked", UserParamType: "bool"},
+				},
+			},
+			{
+				SegmentType: alwaysExperiment,
+				UserConstraints: []userConstraint{
+					{Operator: inSegment, Values: "enterprise"},
+					{Operator: gte, Values: "50", UserParam: "seats", UserParamType: "number"},
+					{Operator: gte, Values: "v2.1.0", UserParam: "version", UserParamType: "semver"},
+				},
+			},
+			{SegmentType: everyoneElse, Percentage: 50},
+		},
+	}
+	ev := testEvaluator(segments, false, f)
+	cf := ev.features[f.Key]
+	user := User{ID: "1234", Params: map[string]interface{}{
+		"country": "US",
+		"blocked": false,
+		"seats":   int64(75),
+		"plan":    "enterprise",
+		"version": "v2.3.0",
+	}}
+	assert.Equal(t, ReasonAlwaysExperiment, ev.evaluate(cf, &user).Reason)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		ev.evaluate(cf, &user)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func TestUserBucketMatchesChecksum(t *testing.T) {
+	for _, id := range []string{"", "1", "1234", "baz", "f603f621-83ba-46f0-adf5-70ed2d668646"} {
+		assert.Equal(t, crc32.ChecksumIEEE([]byte(id))%100, userBucket(id))
+	}
+}
+
+func TestNormalizeVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+		valid    bool
+	}{
+		{"v2.3.0", "v2.3.0", true},
+		{"2.3.0", "v2.3.0", true},
+		{"2.3", "v2.3.0", true},
+		{"2", "v2.0.0", true},
+		{"V2.3.1", "v2.3.1", true},
+		{" 2.3.1 ", "v2.3.1", true},
+		{"2.03.1", "v2.3.1", true},
+		{"2.3.0-beta.1", "v2.3.0-beta.1", true},
+		{"2.3+build.42", "v2.3.0+build.42", true},
+		{"2.3.0.1", "", false},
+		{"two", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			v, ok := normalizeVersion(test.version)
+			assert.Equal(t, test.valid, ok)
+			assert.Equal(t, test.expected, v)
+		})
+	}
+}
+
+func TestSemVerConstraints(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator operator
+		values   string
+		version  string
+		expected bool
+	}{
+		{"equal without v", equals, "2.3.0", "2.3", true},
+		{"different short versions", equals, "2.3", "2.4", false},
+		{"build metadata is ignored", equals, "2.3.0", "2.3.0+42", true},
+		{"pre-release is lower", lt, "2.3.0", "2.3.0-beta", true},
+		{"gte", gte, "v2.1", "2.3", true},
+		{"lt", lt, "2.1", "2.3", false},
+		{"in list", in, "1.0,2.3,3.1.4", "2.3.0", true},
+		{"not in list", in, "1.0,2.3,3.1.4", "2.4", false},
+		{"nin list", nin, "1.0,2.3", "2.4", true},
+		{"in range", inRange, ">=2.1 <3", "2.9.9", true},
+		{"below range", inRange, ">=2.1 <3", "2.0", false},
+		{"above range", inRange, ">=2.1 <3", "3.0.0", false},
+		{"second range", inRange, ">=2.1 <3 || >=4", "4.1", true},
+		{"exact range", inRange, "2.3", "2.3.0", true},
+		{"invalid user version", gte, "2.1", "latest", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user := User{ID: "1", Params: map[string]interface{}{"version": test.version}}
+			segment := featureSegment{UserConstraints: []userConstraint{
+				{Operator: test.operator, Values: constraintValues(test.values), UserParam: "version", UserParamType: "semver"},
+			}}
+			assert.Equal(t, test.expected, isUserInSegment(user, segment))
+		})
+	}
+}
+
+func TestInvalidConstraintValuesAreReportedAtLoad(t *testing.T) {
+	f := feature{Key: "MOBILE", Active: true, Rules: []rule{{UserConstraints: []userConstraint{
+		{Operator: gte, Values: "latest", UserParam: "version", UserParamType: "semver"},
+		{Operator: inRange, Values: ">=2.1 <three", UserParam: "version", UserParamType: "semver"},
+		{Operator: gte, Values: "many", UserParam: "seats", UserParamType: "number"},
+		{Operator: gte, Values: "2.1", UserParam: "version", UserParamType: "semver"},
+	}}}}
+	_, errs := newEvaluator(map[string]feature{f.Key: f}, nil, false)
+	assert.Len(t, errs, 3)
+	user := User{ID: "1", Params: map[string]interface{}{"version": "latest", "seats": "many"}}
+	assert.False(t, evaluateFeature(f, &user).Active)
+}
+
+func TestListAndTimeParams(t *testing.T) {
+	signedUp := time.Date(2020, 8, 26, 2, 11, 44, 0, time.UTC)
+	tests := []struct {
+		name       string
+		constraint userConstraint
+		value      interface{}
+		expected   bool
+	}{
+		{"in matches any value", userConstraint{Operator: in, Values: "admin,owner", UserParam: "p"}, []string{"viewer", "admin"}, true},
+		{"in without a match", userConstraint{Operator: in, Values: "admin,owner", UserParam: "p"}, []string{"viewer"}, false},
+		{"nin needs every value to be missing", userConstraint{Operator: nin, Values: "admin,owner", UserParam: "p"}, []string{"viewer", "admin"}, false},
+		{"nin without a match", userConstraint{Operator: nin, Values: "admin,owner", UserParam: "p"}, []string{"viewer"}, true},
+		{"equals", userConstraint{Operator: equals, Values: "admin", UserParam: "p"}, []string{"viewer", "admin"}, true},
+		{"doesNotContain", userConstraint{Operator: doesNotContain, Values: "adm", UserParam: "p"}, []string{"viewer", "admin"}, false},
+		{"empty list", userConstraint{Operator: in, Values: "admin", UserParam: "p"}, []string{}, false},
+		{"time as a number", userConstraint{Operator: gt, Values: "1598400000", UserParam: "p", UserParamType: "number"}, signedUp, true},
+		{"time as a string", userConstraint{Operator: contains, Values: "2020-08-26", UserParam: "p"}, signedUp, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user := User{ID: "1", Params: map[string]interface{}{"p": test.value}}
+			segment := featureSegment{UserConstraints: []userConstraint{test.constraint}}
+			assert.Equal(t, test.expected, isUserInSegment(user, segment))
+		})
+	}
+}
+
+func TestAssignmentIsSetForEveryOutcome(t *testing.T) {
+	user := &User{ID: "1"}
+	tests := []struct {
+		name       string
+		f          feature
+		user       *User
+		assignment Assignment
+	}{
+		{"inactive", feature{Key: "OFF"}, user, AssignmentControl},
+		{"no user", feature{Key: "ON", Active: true}, nil, AssignmentExperiment},
+		{"fallthrough", feature{Key: "ON", Active: true}, user, AssignmentControl},
+		{"always experiment", feature{Key: "ON", Active: true, Segments: []featureSegment{{SegmentType: alwaysExperiment}}}, user, AssignmentExperiment},
+		{"always control", feature{Key: "ON", Active: true, Segments: []featureSegment{{SegmentType: alwaysControl}}}, user, AssignmentControl},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.assignment, evaluateFeature(test.f, test.user).Assignment)
+		})
+	}
+}
+
+func TestAssignmentGroup(t *testing.T) {
+	assert.Equal(t, AssignmentControl, AssignmentControl.Group())
+	assert.Equal(t, AssignmentExperiment, AssignmentExperiment.Group())
+	assert.Equal(t, AssignmentExperiment, Assignment("one-page").Group())
+	assert.Equal(t, "one-page", Assignment("one-page").variantName())
+	assert.Equal(t, "", AssignmentExperiment.variantName())
+
+	assert.Equal(t, "experiment", testType("one-page", false))
+	assert.Equal(t, "control", testType(AssignmentControl, false))
+	assert.Equal(t, "control", testType("one-page", true))
+	assert.Equal(t, "experiment", testType(AssignmentControl, true))
+}
+
+func TestConstraintValuesDecoding(t *testing.T) {
+	for payload, expected := range map[string]constraintValues{
+		`{"values":"a,b"}`: "a,b",
+		`{"values":1235}`:  "1235",
+		`{"values":14.5}`:  "14.5",
+		`{"values":true}`:  "true",
+		`{"values":null}`:  "",
+	} {
+		var uc userConstraint
+		assert.NoError(t, json.Unmarshal([]byte(payload), &uc), payload)
+		assert.Equal(t, expected, uc.Values, payload)
+	}
+	var uc userConstraint
+	assert.Error(t, json.Unmarshal([]byte(`{"values":["a","b"]}`), &uc))
+}
@@ -0,0 +1,81 @@
+package molasses
+
+import "encoding/json"
+
+// EvaluationResult - The result of evaluating a feature with AllFlags
+type EvaluationResult struct {
+	Active     bool
+	Assignment Assignment
+	Reason     EvaluationReason
+	// Version is the version of the feature that was evaluated
+	Version string
+}
+
+// FlagInfo - What a FlagFilter knows about a feature
+type FlagInfo struct {
+	Key                 string
+	Description         string
+	ClientSideAvailable bool
+}
+
+// FlagFilter - Decides whether a feature is included by AllFlags
+type FlagFilter func(FlagInfo) bool
+
+// ClientSideOnly - A FlagFilter that only keeps features that are available client side, use it for flags that are
+// sent to browsers and mobile apps
+func ClientSideOnly(f FlagInfo) bool {
+	return f.ClientSideAvailable
+}
+
+// AllFlags - Evaluates every feature for the user, or only the ones that every filter keeps. The evaluations are
+// not counted in Usage and do not send experiment_started events, as the flags are usually passed on rather than used.
+func (c *client) AllFlags(user User, filters ...FlagFilter) map[string]EvaluationResult {
+	return c.evaluator().allFlags(&user, filters)
+}
+
+// AllFlags - Evaluates every feature of the snapshot for the user, see the client's AllFlags
+func (s *Snapshot) AllFlags(user User, filters ...FlagFilter) map[string]EvaluationResult {
+	return s.ev.allFlags(&user, filters)
+}
+
+func (ev *evaluator) allFlags(user *User, filters []FlagFilter) map[string]EvaluationResult {
+	flags := make(map[string]EvaluationResult, len(ev.features))
+features:
+	for key, f := range ev.features {
+		info := FlagInfo{Key: key, Description: f.Description, ClientSideAvailable: f.ClientSideAvailable}
+		for _, filter := range filters {
+			if !filter(info) {
+				continue features
+			}
+		}
+		detail := ev.evaluate(f, user)
+		flags[key] = EvaluationResult{
+			Active:     detail.Active,
+			Assignment: detail.Assignment,
+			Reason:     detail.Reason,
+			Version:    f.Version,
+		}
+	}
+	return flags
+}
+
+// bootstrapFlag is a flag in the bootstrap JSON, the assignment is left out for users in the control group
+type bootstrapFlag struct {
+	Active     bool       `json:"active"`
+	Assignment Assignment `json:"assignment,omitempty"`
+}
+
+// BootstrapJSON - Serializes flags from AllFlags into the compact JSON the browser and mobile SDKs start with, such as
+// {"CHECKOUT":{"active":true,"assignment":"one-page"},"GOOGLE_SSO":{"active":false}}. The keys are sorted and
+// the characters <, > and & are escaped, so it can be embedded in an HTML script tag.
+func BootstrapJSON(flags map[string]EvaluationResult) ([]byte, error) {
+	bootstrap := make(map[string]bootstrapFlag, len(flags))
+	for key, flag := range flags {
+		b := bootstrapFlag{Active: flag.Active}
+		if flag.Assignment != AssignmentControl {
+			b.Assignment = flag.Assignment
+		}
+		bootstrap[key] = b
+	}
+	return json.Marshal(bootstrap)
+}
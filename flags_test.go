@@ -0,0 +1,51 @@
+package molasses_test
+
+import (
+	"testing"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const allFlagsPayload = `{"data":{"features":[
+	{"id":"1","key":"CHECKOUT","version":"3","active":true,"clientSideAvailable":true,"rules":[
+		{"id":"enterprise","variant":"one-page","userConstraints":[{"operator":"equals","values":"enterprise","userParam":"plan"}]}]},
+	{"id":"2","key":"GOOGLE_SSO","active":false,"clientSideAvailable":true},
+	{"id":"3","key":"BILLING_V2","active":true,"segments":[{"segmentType":"everyoneElse","percentage":100}]}]}}`
+
+func TestAllFlags(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{AutoSendEvents: true}, allFlagsPayload)
+	user := molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "enterprise"}}
+
+	assert.Equal(t, map[string]molasses.EvaluationResult{
+		"CHECKOUT":   {Active: true, Assignment: "one-page", Reason: molasses.ReasonRuleMatch, Version: "3"},
+		"GOOGLE_SSO": {Active: false, Assignment: molasses.AssignmentControl, Reason: molasses.ReasonFeatureInactive},
+		"BILLING_V2": {Active: true, Assignment: molasses.AssignmentExperiment, Reason: molasses.ReasonPercentage},
+	}, client.AllFlags(user))
+
+	flags := client.AllFlags(user, molasses.ClientSideOnly)
+	assert.Len(t, flags, 2)
+	assert.Contains(t, flags, "CHECKOUT")
+	assert.Contains(t, flags, "GOOGLE_SSO")
+
+	flags = client.Snapshot().AllFlags(user, molasses.ClientSideOnly, func(f molasses.FlagInfo) bool { return f.Key != "GOOGLE_SSO" })
+	assert.Len(t, flags, 1)
+	assert.Contains(t, flags, "CHECKOUT")
+
+	// evaluating every flag is not an exposure
+	assert.Empty(t, events)
+	assert.Equal(t, int64(0), client.Usage()["CHECKOUT"].Evaluations)
+}
+
+func TestBootstrapJSON(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, allFlagsPayload)
+	user := molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "enterprise"}}
+
+	b, err := molasses.BootstrapJSON(client.AllFlags(user, molasses.ClientSideOnly))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"CHECKOUT":{"active":true,"assignment":"one-page"},"GOOGLE_SSO":{"active":false}}`, string(b))
+
+	b, err = molasses.BootstrapJSON(map[string]molasses.EvaluationResult{"<script>": {Active: true, Assignment: molasses.AssignmentExperiment}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"\u003cscript\u003e":{"active":true,"assignment":"experiment"}}`, string(b))
+}
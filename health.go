@@ -0,0 +1,70 @@
+package molasses
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// health is the body written by HealthHandler
+type health struct {
+	Ready       bool            `json:"ready"`
+	Initialized bool            `json:"initialized"`
+	State       ConnectionState `json:"state"`
+	// Mode is streaming or polling, Fallback is set when polling because the stream is unavailable
+	Mode           string     `json:"mode,omitempty"`
+	Fallback       bool       `json:"fallback,omitempty"`
+	DataAgeSeconds float64    `json:"dataAgeSeconds"`
+	LastSuccess    *time.Time `json:"lastSuccess,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	Etag           string     `json:"etag,omitempty"`
+	FlagCount      int        `json:"flagCount"`
+	EventQueue     int        `json:"eventQueueDepth"`
+}
+
+// HealthHandler - Returns an http.Handler that reports the state of the client as JSON, for readiness probes
+// and status pages. It responds with 200 once the client has received features and 503 before then or after Stop.
+func HealthHandler(c ClientInterface) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		status := c.Status()
+		h := health{
+			Initialized:    c.IsInitiated(),
+			State:          status.State,
+			DataAgeSeconds: status.DataAge.Seconds(),
+		}
+		h.Ready = h.Initialized && status.State != StateOffline
+		if !status.LastSuccess.IsZero() {
+			h.LastSuccess = &status.LastSuccess
+		}
+		if status.LastError != nil {
+			h.LastError = status.LastError.Error()
+		}
+		if mc, ok := c.(*client); ok {
+			mc.describe(&h)
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-cache")
+		if !h.Ready {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(rw).Encode(h)
+	})
+}
+
+// describe adds the client's connection mode, features and queued events to a health report
+func (c *client) describe(h *health) {
+	c.mu.Lock()
+	h.Mode = "streaming"
+	if c.polling || c.pollingFallback {
+		h.Mode = "polling"
+	}
+	h.Fallback = c.pollingFallback
+	h.Etag = c.etag
+	c.mu.Unlock()
+
+	h.FlagCount = len(c.evaluator().features)
+	if c.queue != nil {
+		h.EventQueue = c.queue.len()
+	}
+}
@@ -0,0 +1,79 @@
+package molasses_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func checkHealth(t *testing.T, client molasses.ClientInterface) (int, map[string]interface{}) {
+	rec := httptest.NewRecorder()
+	molasses.HealthHandler(client).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return rec.Code, body
+}
+
+func TestHealthHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/features" {
+			rw.Header().Set("Etag", "v1")
+			if _, err := rw.Write([]byte(assignmentPayload)); err != nil {
+				t.Error(err)
+			}
+		}
+	}))
+	defer server.Close()
+
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:        "API_KEY",
+		URL:           server.URL,
+		Polling:       true,
+		BatchEvents:   true,
+		FlushInterval: time.Hour,
+	})
+	assert.NoError(t, err)
+	client.Track("Checkout Started", molasses.User{ID: "1234"}, nil)
+	client.Track("Checkout Submitted", molasses.User{ID: "1234"}, nil)
+
+	code, body := checkHealth(t, client)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["ready"])
+	assert.Equal(t, true, body["initialized"])
+	assert.Equal(t, "VALID", body["state"])
+	assert.Equal(t, "polling", body["mode"])
+	assert.Equal(t, "v1", body["etag"])
+	assert.Equal(t, 1.0, body["flagCount"])
+	assert.Equal(t, 2.0, body["eventQueueDepth"])
+	assert.Contains(t, body, "lastSuccess")
+	assert.Contains(t, body, "dataAgeSeconds")
+
+	client.Stop()
+	code, body = checkHealth(t, client)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "OFFLINE", body["state"])
+	assert.Equal(t, 0.0, body["eventQueueDepth"], "stopping flushes the queue")
+}
+
+func TestHealthHandlerBeforeInitialization(t *testing.T) {
+	server := newStreamServer(t, false)
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey: "API_KEY",
+		URL:    server.URL,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	code, body := checkHealth(t, client)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, false, body["ready"])
+	assert.Equal(t, "INITIALIZING", body["state"])
+	assert.Equal(t, "streaming", body["mode"])
+	assert.Equal(t, 0.0, body["flagCount"])
+}
@@ -0,0 +1,48 @@
+package molasses
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+const (
+	hllPrecision = 10
+	hllRegisters = 1 << hllPrecision
+)
+
+// hyperLogLog estimates the number of distinct values added to it in 4KB, with a standard error of about 3%.
+// Its registers are updated atomically, so values can be added concurrently.
+type hyperLogLog [hllRegisters]uint32
+
+// add records a 64-bit hash of a value
+func (h *hyperLogLog) add(hash uint64) {
+	index := hash >> (64 - hllPrecision)
+	// the sentinel bit caps the rank when the remaining bits are all zero
+	rank := uint32(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	for {
+		current := atomic.LoadUint32(&h[index])
+		if rank <= current || atomic.CompareAndSwapUint32(&h[index], current, rank) {
+			return
+		}
+	}
+}
+
+// estimate returns the estimated number of distinct values, using linear counting for small cardinalities
+func (h *hyperLogLog) estimate() uint64 {
+	const m = float64(hllRegisters)
+	var sum float64
+	var zeros int
+	for i := range h {
+		r := atomic.LoadUint32(&h[i])
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
@@ -0,0 +1,24 @@
+package molasses
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHyperLogLogEstimate allows three times the standard error of the estimate
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100000} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			var h hyperLogLog
+			for i := 0; i < n; i++ {
+				id := strconv.Itoa(i)
+				// adding a value again does not change the estimate
+				h.add(sampleHash(id))
+				h.add(sampleHash(id))
+			}
+			assert.InEpsilon(t, float64(n)+1, float64(h.estimate())+1, 0.1)
+		})
+	}
+}
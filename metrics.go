@@ -0,0 +1,46 @@
+package molasses
+
+import "time"
+
+// Metrics - Receives measurements of the client, such as evaluation counts and fetch latencies.
+// Implementations must be safe for concurrent use and should be fast, IncCounter is called on every evaluation.
+type Metrics interface {
+	// IncCounter adds one to the counter with the name
+	IncCounter(name string)
+	// ObserveDuration records a duration, such as the latency of a request
+	ObserveDuration(name string, d time.Duration)
+}
+
+// The names of the metrics the client reports
+var (
+	MetricEvaluations         = "molasses_evaluations_total"           // MetricEvaluations - features evaluated
+	MetricUnknownFlags        = "molasses_unknown_flags_total"         // MetricUnknownFlags - evaluations of features that are not set
+	MetricFetches             = "molasses_fetches_total"               // MetricFetches - requests for features
+	MetricFetchErrors         = "molasses_fetch_errors_total"          // MetricFetchErrors - requests for features that failed
+	MetricFetchNotModified    = "molasses_fetch_not_modified_total"    // MetricFetchNotModified - requests for features that were answered with 304 Not Modified
+	MetricFetchDuration       = "molasses_fetch_duration_seconds"      // MetricFetchDuration - latency of requests for features
+	MetricStreamReconnects    = "molasses_stream_reconnects_total"     // MetricStreamReconnects - attempts to reconnect to the event stream
+	MetricEventsSent          = "molasses_events_sent_total"           // MetricEventsSent - analytics events uploaded
+	MetricEventsDropped       = "molasses_events_dropped_total"        // MetricEventsDropped - analytics events dropped because the queue was full
+	MetricEventUploadFailures = "molasses_event_upload_failures_total" // MetricEventUploadFailures - analytics events that failed to upload
+)
+
+// metricHelp describes the metrics for the Prometheus exporter
+var metricHelp = map[string]string{
+	MetricEvaluations:         "Feature flag evaluations.",
+	MetricUnknownFlags:        "Evaluations of feature flags that are not set in the environment.",
+	MetricFetches:             "Requests for feature flags.",
+	MetricFetchErrors:         "Requests for feature flags that failed.",
+	MetricFetchNotModified:    "Requests for feature flags answered with 304 Not Modified.",
+	MetricFetchDuration:       "Latency of requests for feature flags.",
+	MetricStreamReconnects:    "Attempts to reconnect to the feature flag stream.",
+	MetricEventsSent:          "Analytics events uploaded.",
+	MetricEventsDropped:       "Analytics events dropped because the event queue was full.",
+	MetricEventUploadFailures: "Analytics events that failed to upload.",
+}
+
+// noopMetrics is used when ClientOptions.Metrics is not set
+type noopMetrics struct{}
+
+func (noopMetrics) IncCounter(string)                     {}
+func (noopMetrics) ObserveDuration(string, time.Duration) {}
@@ -0,0 +1,103 @@
+package molasses_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMetricsServer(t *testing.T) *httptest.Server {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/features":
+			if atomic.AddInt32(&fetches, 1) > 1 {
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+			rw.Header().Set("Etag", "v1")
+			if _, err := rw.Write([]byte(featurePayload(true))); err != nil {
+				t.Error(err)
+			}
+		case "/analytics":
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := molasses.NewPrometheusMetrics()
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:       "API_KEY",
+		URL:          newMetricsServer(t).URL,
+		Polling:      true,
+		PollInterval: 10 * time.Millisecond,
+		Metrics:      metrics,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	client.IsActive("GOOGLE_SSO")
+	client.IsActive("GOOGLE_SSO", molasses.User{ID: "1234"})
+	client.IsActive("MISSING")
+	client.Track("Checkout Started", molasses.User{ID: "1234"}, nil)
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+		return rec.Body.String()
+	}
+	assert.Eventually(t, func() bool {
+		body := scrape()
+		return strings.Contains(body, "molasses_fetch_not_modified_total") &&
+			strings.Contains(body, "molasses_event_upload_failures_total 1\n")
+	}, time.Second, 5*time.Millisecond)
+
+	body := scrape()
+	assert.Contains(t, body, "# HELP molasses_evaluations_total Feature flag evaluations.\n# TYPE molasses_evaluations_total counter\nmolasses_evaluations_total 3\n")
+	assert.Contains(t, body, "molasses_unknown_flags_total 1\n")
+	assert.Contains(t, body, "# TYPE molasses_fetch_duration_seconds summary\n")
+	assert.Contains(t, body, "molasses_fetch_duration_seconds_count")
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := molasses.NewExpvarMetrics("molasses_test")
+	assert.Same(t, expvar.Get("molasses_test"), expvar.Get("molasses_test"))
+	// the map is global to the process, so only the increase made by this test is checked
+	published := func() map[string]float64 {
+		var values map[string]float64
+		assert.NoError(t, json.Unmarshal([]byte(expvar.Get("molasses_test").String()), &values))
+		return values
+	}
+	before := published()
+
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:  "API_KEY",
+		URL:     newMetricsServer(t).URL,
+		Polling: true,
+		Metrics: metrics,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+	client.IsActive("GOOGLE_SSO")
+
+	after := published()
+	assert.Equal(t, 1.0, after[molasses.MetricEvaluations]-before[molasses.MetricEvaluations])
+	assert.Equal(t, 1.0, after[molasses.MetricFetches]-before[molasses.MetricFetches])
+	assert.Equal(t, 1.0, after[molasses.MetricFetchDuration+"_count"]-before[molasses.MetricFetchDuration+"_count"])
+
+	// a second set of metrics with the same name is published in the same map
+	molasses.NewExpvarMetrics("molasses_test").IncCounter(molasses.MetricEvaluations)
+	assert.Equal(t, 2.0, published()[molasses.MetricEvaluations]-before[molasses.MetricEvaluations])
+}
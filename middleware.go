@@ -0,0 +1,264 @@
+package molasses
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// UserExtractor - Builds the user who made a request, it returns false when the request has no user
+type UserExtractor func(req *http.Request) (User, bool)
+
+// MiddlewareOptions - The options for Middleware, the Extractor is required
+type MiddlewareOptions struct {
+	Extractor UserExtractor // Extractor - builds the user of every request, see RequestUserExtractor
+	// FlagsHeader - when set, the response has a header with this name that lists the features evaluated with the
+	// request's context before the response's headers were written, as KEY=assignment pairs
+	FlagsHeader string
+}
+
+// Middleware - Returns net/http middleware that stores the user of every request in its context with WithUser,
+// for the Ctx methods of the client. The context also gets an evaluation cache, so a feature keeps the same value
+// for the whole request.
+func Middleware(options MiddlewareOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			ctx := WithEvaluationCache(req.Context())
+			if options.Extractor != nil {
+				if user, ok := options.Extractor(req); ok {
+					ctx = WithUser(ctx, user)
+				}
+			}
+			req = req.WithContext(ctx)
+			if options.FlagsHeader == "" {
+				next.ServeHTTP(rw, req)
+				return
+			}
+			w := &flagsHeaderWriter{ResponseWriter: rw, header: options.FlagsHeader, cache: evaluationCacheFromContext(ctx)}
+			next.ServeHTTP(w, req)
+			if !w.wroteHeader {
+				w.WriteHeader(http.StatusOK)
+			}
+		})
+	}
+}
+
+// flagsHeaderWriter sets the header that lists the evaluated features just before the response's headers are written
+type flagsHeaderWriter struct {
+	http.ResponseWriter
+	header      string
+	cache       *evaluationCache
+	wroteHeader bool
+}
+
+func (w *flagsHeaderWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if flags := w.cache.header(); flags != "" {
+			w.Header().Set(w.header, flags)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *flagsHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *flagsHeaderWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over to the handler, for WebSocket upgrades, the flags header is not written then
+func (w *flagsHeaderWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response does not support hijacking its connection")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+func (w *flagsHeaderWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// header lists every feature in the cache, with the assignment it was first evaluated to
+func (c *evaluationCache) header() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[string]bool, len(c.order))
+	flags := make([]string, 0, len(c.order))
+	for _, key := range c.order {
+		if seen[key.key] {
+			continue
+		}
+		seen[key.key] = true
+		flags = append(flags, key.key+"="+string(c.details[key].Assignment))
+	}
+	return strings.Join(flags, ", ")
+}
+
+// RequestUserExtractor - Builds users from the headers, cookies, JWT claims, remote address and User-Agent of
+// requests. Each of the maps goes from the name in the request to the name of the user param it is set to.
+type RequestUserExtractor struct {
+	IDHeader string // IDHeader - the header that holds the user's ID
+	IDCookie string // IDCookie - the cookie that holds the user's ID
+	IDClaim  string // IDClaim - the JWT claim that holds the user's ID, such as sub
+	Headers  map[string]string
+	Cookies  map[string]string
+	// Claims are read from the JWT in the Authorization bearer token, or in JWTCookie when it is set. The JWT's
+	// signature is NOT verified, only use claims for targeting and never for access control.
+	Claims    map[string]string
+	JWTCookie string
+	// RemoteIPParam - the param the client's IP address is set to. It is taken from the first X-Forwarded-For
+	// address when TrustForwardedFor is set and from the request's remote address otherwise.
+	RemoteIPParam     string
+	TrustForwardedFor bool
+	// PlatformParam and PlatformVersionParam - the params the operating system of the User-Agent, one of ios,
+	// android, windows, macos, chromeos or linux, and its version are set to
+	PlatformParam        string
+	PlatformVersionParam string
+	Private              []string // Private - params that are marked as private on every user
+}
+
+// Extract - Builds the user who made the request, it returns false when neither an ID nor any param was found.
+// It can be used as the Extractor of Middleware.
+func (e RequestUserExtractor) Extract(req *http.Request) (User, bool) {
+	user := User{Params: map[string]interface{}{}}
+	var claims map[string]interface{}
+	if e.IDClaim != "" || len(e.Claims) > 0 {
+		claims = jwtClaims(e.bearerToken(req))
+	}
+
+	if id, ok := claims[e.IDClaim].(string); ok && e.IDClaim != "" {
+		user.ID = id
+	}
+	if user.ID == "" && e.IDHeader != "" {
+		user.ID = req.Header.Get(e.IDHeader)
+	}
+	if user.ID == "" && e.IDCookie != "" {
+		if cookie, err := req.Cookie(e.IDCookie); err == nil {
+			user.ID = cookie.Value
+		}
+	}
+
+	for header, param := range e.Headers {
+		if value := req.Header.Get(header); value != "" {
+			user.Params[param] = value
+		}
+	}
+	for name, param := range e.Cookies {
+		if cookie, err := req.Cookie(name); err == nil && cookie.Value != "" {
+			user.Params[param] = cookie.Value
+		}
+	}
+	for claim, param := range e.Claims {
+		if value, ok := claimParam(claims[claim]); ok {
+			user.Params[param] = value
+		}
+	}
+	if e.RemoteIPParam != "" {
+		if ip := e.remoteIP(req); ip != "" {
+			user.Params[e.RemoteIPParam] = ip
+		}
+	}
+	if e.PlatformParam != "" || e.PlatformVersionParam != "" {
+		platform, version := parseUserAgent(req.UserAgent())
+		if platform != "" && e.PlatformParam != "" {
+			user.Params[e.PlatformParam] = platform
+		}
+		if version != "" && e.PlatformVersionParam != "" {
+			user.Params[e.PlatformVersionParam] = version
+		}
+	}
+
+	if user.ID == "" && len(user.Params) == 0 {
+		return User{}, false
+	}
+	if len(e.Private) > 0 {
+		user.Private = append([]string(nil), e.Private...)
+	}
+	return user, true
+}
+
+func (e RequestUserExtractor) bearerToken(req *http.Request) string {
+	if e.JWTCookie != "" {
+		if cookie, err := req.Cookie(e.JWTCookie); err == nil {
+			return cookie.Value
+		}
+		return ""
+	}
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func (e RequestUserExtractor) remoteIP(req *http.Request) string {
+	if e.TrustForwardedFor {
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// jwtClaims decodes the claims of a JWT without verifying it, it returns nil for tokens that can not be decoded
+func jwtClaims(token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+// claimParam converts a claim to a user param, lists are only kept when all of their values are strings
+func claimParam(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string, float64, bool:
+		return v, true
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, s)
+		}
+		return values, true
+	}
+	return nil, false
+}
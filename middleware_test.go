@@ -0,0 +1,176 @@
+package molasses_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func jwt(claims string) string {
+	return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2lnbmF0dXJl"
+}
+
+func TestRequestUserExtractor(t *testing.T) {
+	extractor := molasses.RequestUserExtractor{
+		IDClaim:              "sub",
+		IDHeader:             "X-User-ID",
+		Headers:              map[string]string{"X-Team-ID": "teamId"},
+		Cookies:              map[string]string{"plan": "plan"},
+		Claims:               map[string]string{"email": "email", "roles": "roles", "seats": "seats"},
+		RemoteIPParam:        "ip",
+		PlatformParam:        "platform",
+		PlatformVersionParam: "osVersion",
+		Private:              []string{"email"},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.7:52100"
+	req.Header.Set("Authorization", "Bearer "+jwt(`{"sub":"1234","email":"jo@example.com","roles":["admin","billing"],"seats":50}`))
+	req.Header.Set("X-User-ID", "5678")
+	req.Header.Set("X-Team-ID", "12356")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X)")
+	req.AddCookie(&http.Cookie{Name: "plan", Value: "enterprise"})
+
+	user, ok := extractor.Extract(req)
+	assert.True(t, ok)
+	assert.Equal(t, "1234", user.ID)
+	assert.Equal(t, map[string]interface{}{
+		"teamId":    "12356",
+		"plan":      "enterprise",
+		"email":     "jo@example.com",
+		"roles":     []string{"admin", "billing"},
+		"seats":     50.0,
+		"ip":        "203.0.113.7",
+		"platform":  "ios",
+		"osVersion": "17.1",
+	}, user.Params)
+	assert.Equal(t, []string{"email"}, user.Private)
+
+	// the header is used when the token has no ID
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+	user, ok = extractor.Extract(req)
+	assert.True(t, ok)
+	assert.Equal(t, "5678", user.ID)
+	assert.NotContains(t, user.Params, "email")
+}
+
+func TestRequestUserExtractorWithoutUser(t *testing.T) {
+	extractor := molasses.RequestUserExtractor{IDCookie: "uid", JWTCookie: "session", IDClaim: "sub"}
+	_, ok := extractor.Extract(httptest.NewRequest("GET", "/", nil))
+	assert.False(t, ok)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: jwt(`{"sub":"1234"}`)})
+	user, ok := extractor.Extract(req)
+	assert.True(t, ok)
+	assert.Equal(t, "1234", user.ID)
+}
+
+func TestRequestUserExtractorForwardedFor(t *testing.T) {
+	extractor := molasses.RequestUserExtractor{RemoteIPParam: "ip", TrustForwardedFor: true}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.4, 10.0.0.1")
+	user, _ := extractor.Extract(req)
+	assert.Equal(t, "198.51.100.4", user.Params["ip"])
+}
+
+func TestMiddleware(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+	middleware := molasses.Middleware(molasses.MiddlewareOptions{
+		Extractor:   molasses.RequestUserExtractor{IDHeader: "X-User-ID", Headers: map[string]string{"X-Plan": "plan"}}.Extract,
+		FlagsHeader: "X-Molasses-Flags",
+	})
+	handler := middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		user, ok := molasses.UserFromContext(req.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "1234", user.ID)
+		client.IsActiveCtx(req.Context(), "CHECKOUT")
+		client.IsActiveCtx(req.Context(), "CHECKOUT")
+		client.IsActiveCtx(req.Context(), "UNKNOWN")
+		_, _ = rw.Write([]byte("ok"))
+		// features evaluated after the headers are written are not listed
+		client.IsActiveCtx(req.Context(), "LATE")
+	}))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User-ID", "1234")
+	req.Header.Set("X-Plan", "enterprise")
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "CHECKOUT=one-page, UNKNOWN=control", rec.Header().Get("X-Molasses-Flags"))
+	assert.Equal(t, "ok", rec.Body.String())
+	assert.Equal(t, int64(1), client.Usage()["CHECKOUT"].Evaluations)
+}
+
+func TestMiddlewareWithoutUser(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+	middleware := molasses.Middleware(molasses.MiddlewareOptions{
+		Extractor:   molasses.RequestUserExtractor{IDHeader: "X-User-ID"}.Extract,
+		FlagsHeader: "X-Molasses-Flags",
+	})
+	handler := middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, ok := molasses.UserFromContext(req.Context())
+		assert.False(t, ok)
+		client.IsActiveCtx(req.Context(), "CHECKOUT")
+	}))
+
+	// the header is set even when the handler writes nothing
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "CHECKOUT=experiment", rec.Header().Get("X-Molasses-Flags"))
+}
+
+func TestMiddlewareUpgradesConnections(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+	middleware := molasses.Middleware(molasses.MiddlewareOptions{
+		Extractor:   molasses.RequestUserExtractor{IDHeader: "X-User-ID"}.Extract,
+		FlagsHeader: "X-Molasses-Flags",
+	})
+	server := httptest.NewServer(middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		client.IsActiveCtx(req.Context(), "CHECKOUT")
+		assert.Equal(t, http.ErrNotSupported, rw.(http.Pusher).Push("/app.js", nil))
+		hijacker, ok := rw.(http.Hijacker)
+		if !assert.True(t, ok) {
+			return
+		}
+		conn, buf, err := hijacker.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		_ = buf.Flush()
+		line, _ := buf.ReadString('\n')
+		_, _ = buf.WriteString(line)
+		_ = buf.Flush()
+	})))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nX-User-ID: 1234\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n", server.Listener.Addr())
+	assert.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	_, err = conn.Write([]byte("ping\n"))
+	assert.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "ping\n", line)
+}
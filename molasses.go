@@ -7,12 +7,17 @@ package molasses
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	sse "github.com/r3labs/sse/v2"
@@ -26,16 +31,64 @@ type ClientOptions struct {
 	Debug          bool       // Debug - whether to log debug info
 	HTTPClient     HttpClient // HTTPClient - Pass in your own http client
 	AutoSendEvents bool
-	Polling        bool
+	Polling        bool          // Polling - fetch features every PollInterval instead of streaming them
+	PollInterval   time.Duration // PollInterval - defaults to 15 seconds
+	// PollingFallbackAfter - how long the stream can be unavailable before features are polled until it
+	// recovers. Defaults to a minute.
+	PollingFallbackAfter time.Duration
+	StaleAfter           time.Duration // StaleAfter - how long the connection can fail before the features are stale, defaults to 5 minutes
+	OnStatusChange       func(Status)  // OnStatusChange - called with the new status whenever the connection state changes
+	OnError              func(error)   // OnError - called with every error the client logs, such as ErrUnauthorized or ErrInvalidPayload
+	Metrics              Metrics       // Metrics - receives measurements of the client, see NewExpvarMetrics and NewPrometheusMetrics
+	// UsageSummaryInterval - when set, a flag_usage event is sent this often, and on Stop, for every feature
+	// evaluated since the previous one. The usage returned by Usage is always kept.
+	UsageSummaryInterval time.Duration
+	StrictTypes          bool // StrictTypes - only match user params whose Go type matches the constraint's type, mismatches are reported in EvaluationDetail.Errors
+	// PrivateAttributes - user params that are used for evaluation but are never sent to Molasses in events,
+	// on top of the ones marked in User.Private
+	PrivateAttributes []string
+	// PrivateAttributesHashKey - when set, private params are sent as an HMAC-SHA-256 of their value with this key
+	// instead of being removed, so events can still be grouped by them. Keep the key secret: anyone who has it can
+	// recover values such as IP addresses or emails by hashing every candidate.
+	PrivateAttributesHashKey []byte
+	// LegacyTestTypes - label users a feature is active for as control, and the others as experiment, in events.
+	// Older versions of this SDK did so, only enable it for dashboards that were built on those labels.
+	LegacyTestTypes bool
+	// ExposureCacheSize - how many users, features and assignments are remembered to only send one experiment_started
+	// event for each of them per ExposureWindow. Defaults to 10000, a negative size sends an event for every exposure.
+	ExposureCacheSize int
+	ExposureWindow    time.Duration // ExposureWindow - defaults to an hour
+	// BatchEvents - queue events and send them every FlushInterval instead of as soon as they are tracked.
+	// Metrics tracked for the same user, name and tags between two flushes are sent as a single aggregated event.
+	BatchEvents    bool
+	FlushInterval  time.Duration // FlushInterval - defaults to 10 seconds
+	FlushTimeout   time.Duration // FlushTimeout - how long a flush, and Stop, waits for the events to be sent, defaults to 5 seconds
+	EventQueueSize int           // EventQueueSize - events tracked while the queue is full are dropped, defaults to 1000
+	// SampleRate - the share of users, between 0 and 1, whose events are sent. Users are picked by their ID so every
+	// event of a sampled user is kept. Defaults to 1, every user.
+	SampleRate       float64
+	EventSampleRates map[string]float64 // EventSampleRates - sample rates for events with these names, used instead of SampleRate
 }
 
 type ClientInterface interface {
 	IsActive(key string, user ...User) bool
+	IsActiveDetail(key string, user ...User) EvaluationDetail
 	Stop()
 	IsInitiated() bool
+	Status() Status
+	Usage() map[string]FlagUsage
 	Track(eventName string, user User, additionalDetails map[string]interface{})
+	TrackMetric(eventName string, user User, value float64, additionalDetails map[string]interface{})
 	ExperimentStarted(key string, user User, additionalDetails map[string]interface{})
 	ExperimentSuccess(key string, user User, additionalDetails map[string]interface{})
+	IsActiveCtx(ctx context.Context, key string) bool
+	IsActiveDetailCtx(ctx context.Context, key string) EvaluationDetail
+	TrackCtx(ctx context.Context, eventName string, additionalDetails map[string]interface{})
+	TrackMetricCtx(ctx context.Context, eventName string, value float64, additionalDetails map[string]interface{})
+	ExperimentStartedCtx(ctx context.Context, key string, additionalDetails map[string]interface{})
+	ExperimentSuccessCtx(ctx context.Context, key string, additionalDetails map[string]interface{})
+	Snapshot() *Snapshot
+	AllFlags(user User, filters ...FlagFilter) map[string]EvaluationResult
 }
 
 type HttpClient interface {
@@ -46,20 +99,51 @@ type Client struct {
 	client
 }
 type client struct {
-	httpClient        HttpClient
-	apiKey            string
-	url               string
-	debug             bool
-	etag              string
-	polling           bool
-	initiated         bool
-	isStreamConnected bool
-	featuresCache     map[string]feature
-	logger            *log.Logger
-	sseClient         *sse.Client
-	eventsChannel     chan *sse.Event
-	refreshTicker     *time.Ticker
-	autoSendEvents    bool
+	httpClient HttpClient
+	apiKey     string
+	url        string
+	debug      bool
+	polling    bool
+	// mu guards the connection state below, which is updated by the stream and the refresh loop
+	mu                   sync.Mutex
+	etag                 string
+	initiated            bool
+	isStreamConnected    bool
+	streamDownSince      time.Time
+	pollingFallback      bool
+	pollingFallbackAfter time.Duration
+	stopped              bool
+	healthy              bool
+	lastSuccess          time.Time
+	lastError            error
+	lastErrorAt          time.Time
+	lastState            ConnectionState
+	staleAfter           time.Duration
+	onStatusChange       func(Status)
+	onError              func(error)
+	featuresCache        atomic.Value // featuresCache holds the *evaluator compiled from the latest payload
+	loadMu               sync.Mutex
+	logger               *log.Logger
+	sseClient            *sse.Client
+	refreshTicker        *time.Ticker
+	autoSendEvents       bool
+	strictTypes          bool
+	privateAttributes    []string
+	hashKey              []byte
+	legacyTestTypes      bool
+	exposures            *exposureCache
+	sampler              *sampler
+	metrics              Metrics
+	usage                *usageTracker
+	usageSummaries       bool
+	startedAt            time.Time
+	queue                *eventQueue // queue is nil unless events are batched
+	flushTimeout         time.Duration
+	done                 chan struct{}
+	ctx                  context.Context // ctx is done once the client is stopped, which cancels the stream and fetches in progress
+	cancel               context.CancelFunc
+	stopOnce             sync.Once
+	notInitializedOnce   sync.Once
 }
 
 // Init - Creates a new client to interface with Molasses.
@@ -75,26 +159,21 @@ func Init(options ClientOptions) (ClientInterface, error) {
 	molassesLog := log.New(os.Stderr, "[Molasses]", log.LstdFlags)
 	sseClient := sse.NewClient(baseURL + "/event-stream")
 	sseClient.ResponseValidator = func(c *sse.Client, resp *http.Response) error {
-		if resp.StatusCode == 401 || resp.StatusCode == 403 {
-			// molassesLog.Println("Molasses is unauthorized")
-			return errors.New("Molasses is Unauthorized")
-		}
-
-		if resp.StatusCode >= 500 {
-			// molassesLog.Println(fmt.Sprintf("There is an issue connecting to Molasses status code - %v", resp.StatusCode))
-			return fmt.Errorf("There is an issue connecting to Molasses status code - %v", resp.StatusCode)
-		}
-		return nil
+		return responseError(resp)
 	}
 
-	sseClient.ReconnectNotify = func(err error, backoff time.Duration) {
-		molassesLog.Println("Reconnect", err, backoff)
-	}
 	backoffStrategy := backoff.NewExponentialBackOff()
 
 	backoffStrategy.MaxElapsedTime = 0
 	sseClient.ReconnectStrategy = backoffStrategy
-	eventsChannel := make(chan *sse.Event)
+	pollInterval := options.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	pollingFallbackAfter := options.PollingFallbackAfter
+	if pollingFallbackAfter <= 0 {
+		pollingFallbackAfter = defaultPollingFallbackAfter
+	}
 
 	molassesClient := &client{
 		httpClient:        options.HTTPClient,
@@ -105,10 +184,32 @@ func Init(options ClientOptions) (ClientInterface, error) {
 		sseClient:         sseClient,
 		logger:            molassesLog,
 		isStreamConnected: false,
-		eventsChannel:     eventsChannel,
-		refreshTicker:     time.NewTicker(15 * time.Second),
+		streamDownSince:   time.Now(),
+		refreshTicker:     time.NewTicker(pollInterval),
 		autoSendEvents:    options.AutoSendEvents,
+		strictTypes:       options.StrictTypes,
+		privateAttributes: append([]string(nil), options.PrivateAttributes...),
+		hashKey:           append([]byte(nil), options.PrivateAttributesHashKey...),
+		legacyTestTypes:   options.LegacyTestTypes,
+		exposures:         newExposureCache(options.ExposureCacheSize, options.ExposureWindow),
+		done:              make(chan struct{}),
+
+		pollingFallbackAfter: pollingFallbackAfter,
+		staleAfter:           options.StaleAfter,
+		lastState:            StateInitializing,
+		onStatusChange:       options.OnStatusChange,
+		onError:              options.OnError,
+		metrics:              options.Metrics,
+		usage:                newUsageTracker(),
+		startedAt:            time.Now(),
 	}
+	if molassesClient.metrics == nil {
+		molassesClient.metrics = noopMetrics{}
+	}
+	if molassesClient.staleAfter <= 0 {
+		molassesClient.staleAfter = defaultStaleAfter
+	}
+	sseClient.ReconnectNotify = molassesClient.streamFailed
 
 	if molassesClient.httpClient == nil {
 		molassesClient.httpClient = &http.Client{}
@@ -117,26 +218,43 @@ func Init(options ClientOptions) (ClientInterface, error) {
 	if molassesClient.apiKey == "" {
 		return &client{}, errors.New("API KEY must be supplied")
 	}
-	molassesClient.featuresCache = make(map[string]feature)
+	sampler, err := newSampler(options.SampleRate, options.EventSampleRates)
+	if err != nil {
+		return &client{}, err
+	}
+	molassesClient.sampler = sampler
+	molassesClient.ctx, molassesClient.cancel = context.WithCancel(context.Background())
+	ev, _ := newEvaluator(map[string]feature{}, map[string]userSegment{}, molassesClient.strictTypes)
+	molassesClient.featuresCache.Store(ev)
 	if polling {
 		if err := molassesClient.fetchFeatures(); err != nil {
-			molassesClient.logger.Printf("Error fetching molasses client features %v", err)
+			molassesClient.reportError("Error fetching molasses client features %s", err)
 		} else {
 			molassesClient.logger.Println("Molasses is connected, polling, and initiated")
 		}
 	} else {
 		molassesClient.sseClient.Headers["Authorization"] = "Bearer " + molassesClient.apiKey
-		err := sseClient.SubscribeChan("messages", molassesClient.eventsChannel)
-		if err != nil {
-			return &client{}, errors.New("Failed to connect to Molasses channel")
-		}
-		sseClient.OnDisconnect(func(c *sse.Client) {
-			molassesClient.logger.Printf("Client disconnected")
-			molassesClient.isStreamConnected = false
-		})
+		sseClient.ReconnectStrategy = backoff.WithContext(backoffStrategy, molassesClient.ctx)
+		go molassesClient.stream(molassesClient.ctx)
 	}
 
 	go molassesClient.refresh()
+	if options.BatchEvents {
+		molassesClient.queue = newEventQueue(options.EventQueueSize)
+		interval := options.FlushInterval
+		if interval <= 0 {
+			interval = defaultFlushInterval
+		}
+		molassesClient.flushTimeout = options.FlushTimeout
+		if molassesClient.flushTimeout <= 0 {
+			molassesClient.flushTimeout = defaultFlushTimeout
+		}
+		go molassesClient.flushEvents(interval)
+	}
+	if options.UsageSummaryInterval > 0 {
+		molassesClient.usageSummaries = true
+		go molassesClient.summarizeUsage(options.UsageSummaryInterval)
+	}
 	return molassesClient, nil
 }
 
@@ -144,184 +262,291 @@ func Init(options ClientOptions) (ClientInterface, error) {
 // You must pass the key of the feature (ex. SHOW_USER_ONBOARDING) and optionally pass the user who you are evaluating.
 // if you pass more than 1 user value, the first will only be evaluated
 func (c *client) IsActive(key string, user ...User) bool {
-	f, ok := c.featuresCache[key]
+	return c.IsActiveDetail(key, user...).Active
+}
+
+// IsActiveDetail - Check to see if a feature is active for a user and get the reason for the result.
+// It takes the same arguments as IsActive.
+func (c *client) IsActiveDetail(key string, user ...User) EvaluationDetail {
+	return c.evaluate(c.evaluator(), key, user...)
+}
+
+// evaluate evaluates a feature with the given evaluator, counting its usage and sending its exposure
+func (c *client) evaluate(ev *evaluator, key string, user ...User) EvaluationDetail {
+	c.metrics.IncCounter(MetricEvaluations)
+	f, ok := ev.features[key]
 	if !ok {
+		c.metrics.IncCounter(MetricUnknownFlags)
+		if !c.IsInitiated() {
+			// evaluations happen too often to report every one made before the features arrive
+			c.notInitializedOnce.Do(func() { c.reportError("Warning - %s", ErrNotInitialized) })
+		}
 		c.logger.Printf("Warning - feature flag %s not set in environment -", key)
-		return false
+		return EvaluationDetail{Key: key, Reason: ReasonFeatureNotFound, Assignment: AssignmentControl}
 	}
 	switch len(user) {
 	case 0:
-		return isActive(f, nil)
+		detail := ev.evaluate(f, nil)
+		f.usage.record(&detail, nil)
+		return detail
 	default:
-		result := isActive(f, &user[0])
-		var r = "experiment"
-		if result {
-			r = "control"
-		}
+		detail := ev.evaluate(f, &user[0])
+		f.usage.record(&detail, &user[0])
 		defer func() {
-			if c.autoSendEvents {
+			if c.autoSendEvents && !c.exposures.exposed(exposureKey{user[0].ID, key, detail.Assignment}) {
 				if err := c.uploadEvent(eventOptions{
 					Event:       "experiment_started",
-					Tags:        user[0].Params,
+					Tags:        c.eventTags(user[0], nil),
 					UserID:      user[0].ID,
 					FeatureID:   f.ID,
 					FeatureName: key,
-					TestType:    r,
+					TestType:    testType(detail.Assignment, c.legacyTestTypes),
+					Variant:     detail.Assignment.variantName(),
 				}); err != nil {
-					c.logger.Printf("Error uploading experiment started event- %s", err.Error())
+					c.reportError("Error uploading experiment started event- %s", err)
 				}
 			}
 
 		}()
-		return result
+		return detail
 	}
 }
 
 func (c *client) IsInitiated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.initiated
 }
 
 func (c *client) ExperimentStarted(key string, user User, additionalDetails map[string]interface{}) {
 
-	if !c.initiated {
+	if !c.IsInitiated() {
+		c.reportError("Error uploading event- %s", ErrNotInitialized)
 		return
 	}
 
-	f := c.featuresCache[key]
-	result := isActive(f, &user)
-
-	var r = "experiment"
-	if result {
-		r = "control"
-	}
-
-	for k, v := range additionalDetails {
-		user.Params[k] = v
+	featureID, assignment := c.assignment(key, user)
+	if c.exposures.exposed(exposureKey{user.ID, key, assignment}) {
+		return
 	}
-
 	if err := c.uploadEvent(eventOptions{
 		Event:       "experiment_started",
-		Tags:        user.Params,
+		Tags:        c.eventTags(user, additionalDetails),
 		UserID:      user.ID,
-		FeatureID:   f.ID,
+		FeatureID:   featureID,
 		FeatureName: key,
-		TestType:    r,
+		TestType:    testType(assignment, c.legacyTestTypes),
+		Variant:     assignment.variantName(),
 	}); err != nil {
-		c.logger.Printf("Error uploading event- %s", err.Error())
+		c.reportError("Error uploading event- %s", err)
 	}
 }
 
 func (c *client) Track(eventName string, user User, additionalDetails map[string]interface{}) {
 
-	for k, v := range additionalDetails {
-		user.Params[k] = v
-	}
-
 	if err := c.uploadEvent(eventOptions{
 		Event:  eventName,
-		Tags:   user.Params,
+		Tags:   c.eventTags(user, additionalDetails),
 		UserID: user.ID,
 	}); err != nil {
-		c.logger.Printf("Error uploading event- %s", err.Error())
+		c.reportError("Error uploading event- %s", err)
 	}
 }
 
 func (c *client) ExperimentSuccess(key string, user User, additionalDetails map[string]interface{}) {
 
-	if !c.initiated {
+	if !c.IsInitiated() {
+		c.reportError("Error uploading event- %s", ErrNotInitialized)
 		return
 	}
 
-	f := c.featuresCache[key]
-	result := isActive(f, &user)
-
-	var r = "experiment"
-	if result {
-		r = "control"
+	featureID, assignment := c.assignment(key, user)
+	if err := c.uploadEvent(eventOptions{
+		Event:       "experiment_success",
+		Tags:        c.eventTags(user, additionalDetails),
+		UserID:      user.ID,
+		FeatureID:   featureID,
+		FeatureName: key,
+		TestType:    testType(assignment, c.legacyTestTypes),
+		Variant:     assignment.variantName(),
+	}); err != nil {
+		c.reportError("Error uploading event- %s", err)
 	}
+}
 
-	for k, v := range additionalDetails {
-		user.Params[k] = v
+// assignment evaluates a feature for an event, users are in the control group of features that are not set
+func (c *client) assignment(key string, user User) (string, Assignment) {
+	ev := c.evaluator()
+	f, ok := ev.features[key]
+	if !ok {
+		return "", AssignmentControl
 	}
+	return f.ID, ev.evaluate(f, &user).Assignment
+}
 
+// eventTags merges the user's params with the event's details, without the private params
+func (c *client) eventTags(user User, additionalDetails map[string]interface{}) map[string]interface{} {
+	tags := copyParams(user.Params, additionalDetails)
+	redactParams(tags, c.hashKey, c.privateAttributes, user.Private)
+	return tags
+}
+
+// TrackMetric - Track an event with a numeric value, such as revenue or latency, for a user.
+// With BatchEvents enabled the values tracked for the same user, name and tags are aggregated until the next flush.
+func (c *client) TrackMetric(eventName string, user User, value float64, additionalDetails map[string]interface{}) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		c.reportError("Error uploading event- %s", fmt.Errorf("%w: %s is %v", ErrInvalidMetricValue, eventName, value))
+		return
+	}
 	if err := c.uploadEvent(eventOptions{
-		Event:       "experiment_success",
-		Tags:        user.Params,
-		UserID:      user.ID,
-		FeatureID:   f.ID,
-		FeatureName: key,
-		TestType:    r,
+		Event:  eventName,
+		Tags:   c.eventTags(user, additionalDetails),
+		UserID: user.ID,
+		Value:  &value,
 	}); err != nil {
-		c.logger.Printf("Error uploading event- %s", err.Error())
+		c.reportError("Error uploading event- %s", err)
 	}
 }
 
+// Stop - Stops refreshing features and sends the events that are still queued
 func (c *client) Stop() {
-	c.sseClient.Unsubscribe(c.eventsChannel)
 	c.refreshTicker.Stop()
+	c.mu.Lock()
 	c.initiated = false
+	c.stopped = true
+	c.mu.Unlock()
+	c.updateStatus()
+	c.stopOnce.Do(func() {
+		if c.cancel != nil {
+			c.cancel()
+		}
+		close(c.done)
+		if c.usageSummaries {
+			c.sendUsageSummaries()
+		}
+		c.flush()
+	})
 }
 
 func (c *client) refresh() {
 	for {
 		select {
-		case res := <-c.eventsChannel:
-			data := res.Data
-			var f featuresResponse
-			err := json.Unmarshal(data, &f)
-			if err != nil {
-				c.logger.Printf("Error refreshing features - %s", err.Error())
-			}
-			for _, feature := range f.Data.Features {
-				key := feature.Key
-				c.featuresCache[key] = feature
-			}
-
-			if !c.isStreamConnected {
-				c.logger.Println("Molasses is connected")
-			}
-			if !c.initiated {
-				c.logger.Println("Molasses is initiated")
-			}
-			c.isStreamConnected = true
-			c.initiated = true
 		case <-c.refreshTicker.C:
-			if c.polling {
+			if c.shouldPoll() {
 				if err := c.fetchFeatures(); err != nil {
-					c.logger.Printf("Error refreshing features - %s", err.Error())
+					c.reportError("Error refreshing features - %s", err)
 				}
 			}
+			// features become stale without any request failing
+			c.updateStatus()
+		case <-c.done:
+			return
 		}
 	}
 }
 
+// loadFeatures merges the features and segments from a payload into the current ones and
+// compiles them. Evaluations that are in progress keep using the previous evaluator.
+func (c *client) loadFeatures(data features) {
+	c.loadMu.Lock()
+	defer c.loadMu.Unlock()
+
+	current := c.evaluator()
+	featuresCache := make(map[string]feature, len(current.raw)+len(data.Features))
+	for key, feature := range current.raw {
+		featuresCache[key] = feature
+	}
+	for _, feature := range data.Features {
+		key := feature.Key
+		featuresCache[key] = feature
+	}
+	segmentsCache := make(map[string]userSegment, len(current.rawSegments)+len(data.Segments))
+	for id, segment := range current.rawSegments {
+		segmentsCache[id] = segment
+	}
+	for _, segment := range data.Segments {
+		segmentsCache[segment.ID] = segment
+	}
+
+	ev, errs := newEvaluator(featuresCache, segmentsCache, c.strictTypes)
+	for _, err := range errs {
+		c.logger.Printf("Warning - %s", err.Error())
+	}
+	c.usage.attach(ev)
+	c.featuresCache.Store(ev)
+}
+
+func (c *client) evaluator() *evaluator {
+	return c.featuresCache.Load().(*evaluator)
+}
+
 type features struct {
-	Features []feature `json:"features"`
+	Features []feature     `json:"features"`
+	Segments []userSegment `json:"segments"`
 }
 type featuresResponse struct {
 	Data features `json:"data"`
 }
 
+// uploadEvent drops the event when its user is not sampled and queues or sends it otherwise
 func (c *client) uploadEvent(e eventOptions) error {
-	body, err := json.Marshal(e)
+	e.SampleRate = c.sampler.sampleRate(e.Event)
+	if !sampled(e.UserID, e.SampleRate) {
+		return nil
+	}
+	return c.queueOrSendEvent(e)
+}
+
+// queueOrSendEvent queues the event when events are batched and sends it otherwise
+func (c *client) queueOrSendEvent(e eventOptions) error {
+	if c.queue != nil {
+		if !c.queue.push(e) {
+			c.metrics.IncCounter(MetricEventsDropped)
+			return fmt.Errorf("event queue is full, dropping %s event", e.Event)
+		}
+		return nil
+	}
+	return c.sendEvent(e)
+}
+
+// sendEvent posts an event to the analytics endpoint in the background
+func (c *client) sendEvent(e eventOptions) error {
+	req, err := c.analyticsRequest(e)
 	if err != nil {
 		return err
 	}
+	go func() {
+		res, err := c.httpClient.Do(req)
+		if err == nil {
+			res.Body.Close()
+			err = responseError(res)
+		}
+		if err != nil {
+			c.metrics.IncCounter(MetricEventUploadFailures)
+			c.reportError("Error uploading event to analytics HTTP endpoint - %s", err)
+			return
+		}
+		c.metrics.IncCounter(MetricEventsSent)
+	}()
+	return nil
+}
+
+// analyticsRequest builds the request that uploads the body, an event or a list of events, to the analytics endpoint
+func (c *client) analyticsRequest(v interface{}) (*http.Request, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest("POST", c.url+"/analytics", bytes.NewBuffer(body))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	if c.etag != "" {
-		req.Header.Add("If-None-Match", c.etag)
+	if etag := c.currentEtag(); etag != "" {
+		req.Header.Add("If-None-Match", etag)
 	}
 	req.Header.Add("Authorization", "Bearer "+c.apiKey)
-	go func() {
-		if _, err := c.httpClient.Do(req); err != nil {
-			c.logger.Printf("Error uploading event to analytics HTTP endpoint - %s", err.Error())
-		}
-	}()
-	return nil
+	return req, nil
 }
 
 type eventOptions struct {
@@ -331,6 +556,12 @@ type eventOptions struct {
 	Event       string                 `json:"event"`
 	Tags        map[string]interface{} `json:"tags"`
 	TestType    string                 `json:"testType"`
+	Variant     string                 `json:"variant,omitempty"`
+	Value       *float64               `json:"value,omitempty"`
+	// Aggregate summarizes a batched metric, Value is then the sum of the values tracked
+	Aggregate *metricAggregate `json:"aggregate,omitempty"`
+	// SampleRate is the share of users whose events with this name are sent, each event stands for 1/SampleRate events
+	SampleRate float64 `json:"sampleRate"`
 }
 
 func (c *client) fetchFeatures() error {
@@ -338,25 +569,54 @@ func (c *client) fetchFeatures() error {
 	if err != nil {
 		return err
 	}
-	if c.etag != "" {
-		req.Header.Add("If-None-Match", c.etag)
+	req = req.WithContext(c.ctx)
+	if etag := c.currentEtag(); etag != "" {
+		req.Header.Add("If-None-Match", etag)
 	}
 	req.Header.Add("Authorization", "Bearer "+c.apiKey)
+	c.metrics.IncCounter(MetricFetches)
+	start := time.Now()
 	res, err := c.httpClient.Do(req)
+	c.metrics.ObserveDuration(MetricFetchDuration, time.Since(start))
 	if err != nil {
+		c.metrics.IncCounter(MetricFetchErrors)
+		c.recordError(err, false)
 		return err
 	}
+	defer res.Body.Close()
 	if res.StatusCode == http.StatusNotModified {
+		c.metrics.IncCounter(MetricFetchNotModified)
+		c.recordSuccess()
 		return nil
 	}
-	var b featuresResponse
-
-	_ = json.NewDecoder(res.Body).Decode(&b)
-	for _, feature := range b.Data.Features {
-		key := feature.Key
-		c.featuresCache[key] = feature
+	if err := responseError(res); err != nil {
+		c.metrics.IncCounter(MetricFetchErrors)
+		c.recordError(err, false)
+		return err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.metrics.IncCounter(MetricFetchErrors)
+		c.recordError(err, false)
+		return err
+	}
+	data, err := decodePayload(body)
+	if err != nil {
+		c.metrics.IncCounter(MetricFetchErrors)
+		c.recordError(err, false)
+		return err
 	}
+	c.loadFeatures(data)
+	c.mu.Lock()
 	c.initiated = true
 	c.etag = res.Header.Get("Etag")
+	c.mu.Unlock()
+	c.recordSuccess()
 	return nil
 }
+
+func (c *client) currentEtag() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.etag
+}
@@ -0,0 +1,75 @@
+package molasses
+
+import "fmt"
+
+// prerequisite is a feature that must be active for the user before the dependent feature is evaluated
+type prerequisite struct {
+	Key string `json:"key"`
+}
+
+// prerequisitesMet evaluates the prerequisites of a feature for the same user,
+// returning the key of the first one that is not active
+func (e *evaluation) prerequisitesMet(f *compiledFeature) (string, bool) {
+	if len(f.Prerequisites) == 0 {
+		return "", true
+	}
+	if f.cyclic {
+		e.errors = append(e.errors, fmt.Errorf("feature %s has a prerequisite cycle", f.Key))
+		return "", false
+	}
+	for _, p := range f.Prerequisites {
+		pf, ok := e.features[p.Key]
+		if !ok {
+			e.errors = append(e.errors, fmt.Errorf("prerequisite feature %s not set in environment", p.Key))
+			return p.Key, false
+		}
+		if !e.evaluate(pf).Active {
+			return p.Key, false
+		}
+	}
+	return "", true
+}
+
+// findPrerequisiteCycles returns the keys of every feature that is part of a prerequisite cycle
+func findPrerequisiteCycles(features map[string]feature) map[string]bool {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	cycles := map[string]bool{}
+	state := map[string]int{}
+	var path []string
+
+	var visit func(key string)
+	visit = func(key string) {
+		state[key] = visiting
+		path = append(path, key)
+		for _, p := range features[key].Prerequisites {
+			if _, ok := features[p.Key]; !ok {
+				continue
+			}
+			switch state[p.Key] {
+			case unvisited:
+				visit(p.Key)
+			case visiting:
+				// everything on the path since the prerequisite was first seen is part of the cycle
+				for i := len(path) - 1; i >= 0; i-- {
+					cycles[path[i]] = true
+					if path[i] == p.Key {
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[key] = visited
+	}
+
+	for key := range features {
+		if state[key] == unvisited {
+			visit(key)
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,88 @@
+package molasses
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type durationSummary struct {
+	count int64
+	sum   float64
+}
+
+// PrometheusMetrics - Metrics kept in memory and served in the Prometheus text format by Handler.
+// Durations are exported as summaries without quantiles.
+type PrometheusMetrics struct {
+	mu        sync.Mutex
+	counters  map[string]int64
+	durations map[string]*durationSummary
+}
+
+// NewPrometheusMetrics - Creates an empty set of metrics
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		counters:  map[string]int64{},
+		durations: map[string]*durationSummary{},
+	}
+}
+
+// IncCounter - Adds one to the counter with the name
+func (p *PrometheusMetrics) IncCounter(name string) {
+	p.mu.Lock()
+	p.counters[name]++
+	p.mu.Unlock()
+}
+
+// ObserveDuration - Adds the duration to the summary with the name
+func (p *PrometheusMetrics) ObserveDuration(name string, d time.Duration) {
+	p.mu.Lock()
+	s, ok := p.durations[name]
+	if !ok {
+		s = &durationSummary{}
+		p.durations[name] = s
+	}
+	s.count++
+	s.sum += d.Seconds()
+	p.mu.Unlock()
+}
+
+// Handler - Serves the metrics in the Prometheus text exposition format
+func (p *PrometheusMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = rw.Write(p.text())
+	})
+}
+
+// text renders the metrics sorted by name so the output is stable
+func (p *PrometheusMetrics) text() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.counters)+len(p.durations))
+	for name := range p.counters {
+		names = append(names, name)
+	}
+	for name := range p.durations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []byte
+	for _, name := range names {
+		if help, ok := metricHelp[name]; ok {
+			out = append(out, fmt.Sprintf("# HELP %s %s\n", name, help)...)
+		}
+		if s, ok := p.durations[name]; ok {
+			out = append(out, fmt.Sprintf("# TYPE %s summary\n%s_sum %s\n%s_count %d\n",
+				name, name, strconv.FormatFloat(s.sum, 'g', -1, 64), name, s.count)...)
+			continue
+		}
+		out = append(out, fmt.Sprintf("# TYPE %s counter\n%s %d\n", name, name, p.counters[name])...)
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package molasses
+
+// rule is an ordered targeting rule. Rules are evaluated in order and the first rule whose
+// constraints the user meets, and whose percentage rollout the user falls in, decides the variant.
+type rule struct {
+	ID              string           `json:"id"`
+	UserConstraints []userConstraint `json:"userConstraints"`
+	Constraint      operator         `json:"constraint"`
+	// Constraints is a tree of constraints that is used instead of UserConstraints when it is set
+	Constraints *constraintNode `json:"constraints"`
+	// SegmentID references a named segment whose constraints are used instead of UserConstraints
+	SegmentID string `json:"segmentId"`
+	// Percentage of matching users the rule applies to, every matching user when it is not set
+	Percentage *int `json:"percentage"`
+	// Variant the user is given, the feature is active for every variant except control
+	Variant Assignment `json:"variant"`
+
+	reason EvaluationReason
+}
+
+func (r rule) variant() Assignment {
+	if r.Variant == "" {
+		return AssignmentExperiment
+	}
+	return r.Variant
+}
+
+func (e *evaluation) isUserInRule(r *compiledRule) bool {
+	if r.segmentID != "" {
+		if !e.isUserInNamedSegment(r.segmentID) {
+			return false
+		}
+	} else if !e.meetsNode(&r.node) {
+		return false
+	}
+	if r.percentage < 0 {
+		return true
+	}
+	return getUserPercentage(*e.user, r.percentage)
+}
+
+// targetingRules returns the rules of a feature and the reason used when none of them match.
+// Features without rules have their segments converted: every alwaysControl segment, then every
+// alwaysExperiment segment, then the first everyoneElse segment's percentage.
+func (f feature) targetingRules() ([]rule, EvaluationReason) {
+	if len(f.Rules) > 0 {
+		rules := make([]rule, len(f.Rules))
+		for i, r := range f.Rules {
+			r.reason = ReasonRuleMatch
+			rules[i] = r
+		}
+		return rules, ReasonFallthrough
+	}
+
+	rules := make([]rule, 0, len(f.Segments))
+	for _, st := range []segmentType{alwaysControl, alwaysExperiment} {
+		variant := AssignmentExperiment
+		reason := ReasonAlwaysExperiment
+		if st == alwaysControl {
+			variant = AssignmentControl
+			reason = ReasonAlwaysControl
+		}
+		for _, s := range f.Segments {
+			if s.SegmentType != st {
+				continue
+			}
+			rules = append(rules, rule{
+				UserConstraints: s.UserConstraints,
+				Constraint:      s.Constraint,
+				Constraints:     s.Constraints,
+				SegmentID:       s.SegmentID,
+				Variant:         variant,
+				reason:          reason,
+			})
+		}
+	}
+	for _, s := range f.Segments {
+		if s.SegmentType == everyoneElse {
+			percentage := s.Percentage
+			rules = append(rules, rule{Percentage: &percentage, Variant: AssignmentExperiment, reason: ReasonPercentage})
+			break
+		}
+	}
+	return rules, ReasonPercentage
+}
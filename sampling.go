@@ -0,0 +1,77 @@
+package molasses
+
+import (
+	"fmt"
+	"math"
+)
+
+// sampler decides which users' events are sent. The decision only depends on the user's ID so
+// that every event of a sampled user is kept.
+type sampler struct {
+	rate       float64
+	eventRates map[string]float64
+}
+
+func newSampler(rate float64, eventRates map[string]float64) (*sampler, error) {
+	if rate == 0 {
+		rate = 1
+	}
+	if err := validSampleRate(rate); err != nil {
+		return nil, fmt.Errorf("SampleRate %v", err)
+	}
+	s := &sampler{rate: rate, eventRates: make(map[string]float64, len(eventRates))}
+	for name, r := range eventRates {
+		if err := validSampleRate(r); err != nil {
+			return nil, fmt.Errorf("sample rate of %s %v", name, err)
+		}
+		s.eventRates[name] = r
+	}
+	return s, nil
+}
+
+func validSampleRate(rate float64) error {
+	if math.IsNaN(rate) || rate < 0 || rate > 1 {
+		return fmt.Errorf("must be between 0 and 1, got %v", rate)
+	}
+	return nil
+}
+
+// sampleRate returns the rate events with the name are sampled at
+func (s *sampler) sampleRate(event string) float64 {
+	if r, ok := s.eventRates[event]; ok {
+		return r
+	}
+	return s.rate
+}
+
+// sampled reports whether the user's events are kept at the rate
+func sampled(userID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return float64(sampleHash(userID))/(1<<64) < rate
+}
+
+// sampleHash is the 64-bit FNV-1a hash of the user's ID, finalized like MurmurHash3 so short IDs
+// spread over every bit. It is deliberately not the checksum used for percentage rollouts so that
+// the sampled users are spread evenly over every variant.
+func sampleHash(id string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(id); i++ {
+		h ^= uint64(id[i])
+		h *= prime
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
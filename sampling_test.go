@@ -0,0 +1,50 @@
+package molasses
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampledIsDeterministic(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		id := strconv.Itoa(i)
+		assert.Equal(t, sampled(id, 0.3), sampled(id, 0.3))
+		if sampled(id, 0.3) {
+			assert.True(t, sampled(id, 0.6), "users sampled at a rate are sampled at every higher rate")
+		}
+	}
+	assert.True(t, sampled("1", 1))
+	assert.False(t, sampled("1", 0))
+}
+
+func TestSampledUsersAreSpreadOverRollouts(t *testing.T) {
+	const users = 20000
+	var kept, inRollout int
+	for i := 0; i < users; i++ {
+		id := strconv.Itoa(i)
+		if !sampled(id, 0.25) {
+			continue
+		}
+		kept++
+		if userBucket(id) < 50 {
+			inRollout++
+		}
+	}
+	assert.InDelta(t, 0.25, float64(kept)/users, 0.02)
+	assert.InDelta(t, 0.5, float64(inRollout)/float64(kept), 0.03)
+}
+
+func TestNewSampler(t *testing.T) {
+	s, err := newSampler(0, map[string]float64{"experiment_started": 0.1, "page_view": 0})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, s.sampleRate("Checkout Started"))
+	assert.Equal(t, 0.1, s.sampleRate("experiment_started"))
+	assert.Equal(t, 0.0, s.sampleRate("page_view"))
+
+	_, err = newSampler(1.5, nil)
+	assert.Error(t, err)
+	_, err = newSampler(0.5, map[string]float64{"page_view": -1})
+	assert.Error(t, err)
+}
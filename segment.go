@@ -0,0 +1,85 @@
+package molasses
+
+import (
+	"fmt"
+	"sync"
+)
+
+// userSegment is a named set of user constraints that is defined once for the environment and
+// referenced by ID from feature segments or from constraints with the segment operator
+type userSegment struct {
+	ID              string           `json:"id"`
+	Name            string           `json:"name"`
+	UserConstraints []userConstraint `json:"userConstraints"`
+	Constraint      operator         `json:"constraint"`
+	// Constraints is a tree of constraints that is used instead of UserConstraints when it is set
+	Constraints *constraintNode `json:"constraints"`
+}
+
+type segmentResult uint8
+
+const (
+	segmentUnknown segmentResult = iota
+	segmentEvaluating
+	segmentMatched
+	segmentNotMatched
+)
+
+// segmentResultsPool reuses the memo of named segment results between evaluations
+var segmentResultsPool = sync.Pool{
+	New: func() interface{} {
+		return new([]segmentResult)
+	},
+}
+
+// isUserInNamedSegment checks if the user is in a named segment, each segment is only evaluated once per evaluation
+func (e *evaluation) isUserInNamedSegment(id string) bool {
+	s, ok := e.segments[id]
+	if !ok {
+		e.errors = append(e.errors, fmt.Errorf("segment %s not set in environment", id))
+		return false
+	}
+	if e.segmentResults == nil {
+		results := segmentResultsPool.Get().(*[]segmentResult)
+		if cap(*results) < len(e.segments) {
+			*results = make([]segmentResult, len(e.segments))
+		}
+		*results = (*results)[:len(e.segments)]
+		for i := range *results {
+			(*results)[i] = segmentUnknown
+		}
+		e.segmentResults = results
+	}
+	results := *e.segmentResults
+	switch results[s.index] {
+	case segmentMatched:
+		return true
+	case segmentNotMatched, segmentEvaluating:
+		// a segment that ends up referencing itself is not matched
+		return false
+	}
+	results[s.index] = segmentEvaluating
+	result := e.meetsNode(&s.node)
+	results[s.index] = segmentNotMatched
+	if result {
+		results[s.index] = segmentMatched
+	}
+	return result
+}
+
+// isUserInAnyNamedSegment checks if the user is in at least one of the segments
+func (e *evaluation) isUserInAnyNamedSegment(ids []string) bool {
+	for _, id := range ids {
+		if e.isUserInNamedSegment(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *evaluation) releaseSegmentResults() {
+	if e.segmentResults != nil {
+		segmentResultsPool.Put(e.segmentResults)
+		e.segmentResults = nil
+	}
+}
@@ -0,0 +1,131 @@
+package molasses
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// normalizeVersion turns a real-world version string into the canonical form golang.org/x/mod/semver
+// expects: the leading v is optional, a missing minor or patch version is 0 and leading zeros are dropped.
+// Pre-release and build metadata are kept. Versions that are already valid are returned as they are.
+func normalizeVersion(v string) (string, bool) {
+	if semver.IsValid(v) {
+		return v, true
+	}
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(strings.TrimPrefix(v, "v"), "V")
+
+	var suffix string
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v, suffix = v[:i], v[i:]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return "", false
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return "", false
+		}
+		parts[i] = strconv.FormatUint(n, 10)
+	}
+	normalized := "v" + strings.Join(parts, ".") + suffix
+	if !semver.IsValid(normalized) {
+		return "", false
+	}
+	return normalized, true
+}
+
+// versionComparator is a single comparison in a range such as >=2.1
+type versionComparator struct {
+	operator operator
+	version  string
+}
+
+// versionRange is a set of comparators that must all be met, joined by || with other sets:
+//
+//	>=2.1 <3 || >=4
+type versionRange [][]versionComparator
+
+var comparatorPrefixes = []struct {
+	prefix   string
+	operator operator
+}{
+	{">=", gte},
+	{"<=", lte},
+	{"!=", doesNotEqual},
+	{">", gt},
+	{"<", lt},
+	{"=", equals},
+}
+
+func parseVersionRange(s string) (versionRange, error) {
+	var r versionRange
+	for _, group := range strings.Split(s, "||") {
+		fields := strings.Fields(group)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("empty version range in %q", s)
+		}
+		comparators := make([]versionComparator, 0, len(fields))
+		for _, field := range fields {
+			c := versionComparator{operator: equals}
+			for _, p := range comparatorPrefixes {
+				if strings.HasPrefix(field, p.prefix) {
+					c.operator = p.operator
+					field = field[len(p.prefix):]
+					break
+				}
+			}
+			v, ok := normalizeVersion(field)
+			if !ok {
+				return nil, fmt.Errorf("invalid version %q in range %q", field, s)
+			}
+			c.version = v
+			comparators = append(comparators, c)
+		}
+		r = append(r, comparators)
+	}
+	return r, nil
+}
+
+func (r versionRange) contains(v string) bool {
+	for _, group := range r {
+		matched := true
+		for _, c := range group {
+			if !compareVersions(c.operator, semver.Compare(v, c.version)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions checks the result of semver.Compare against a comparison operator
+func compareVersions(op operator, comparison int) bool {
+	switch op {
+	case equals:
+		return comparison == 0
+	case doesNotEqual:
+		return comparison != 0
+	case gt:
+		return comparison > 0
+	case lt:
+		return comparison < 0
+	case gte:
+		return comparison >= 0
+	case lte:
+		return comparison <= 0
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package molasses
+
+import "context"
+
+// Snapshot - An immutable view of the features at the time it was taken. Every evaluation made with a snapshot
+// sees the same version of every feature, even when the client receives new features meanwhile, so a request or
+// a batch job gets consistent results. Evaluations still count towards Usage and send exposure events.
+type Snapshot struct {
+	client *client
+	ev     *evaluator
+}
+
+// Snapshot - Returns a view of the features as they are now
+func (c *client) Snapshot() *Snapshot {
+	return &Snapshot{client: c, ev: c.evaluator()}
+}
+
+// IsActive - Check to see if a feature is active for a user, see the client's IsActive
+func (s *Snapshot) IsActive(key string, user ...User) bool {
+	return s.client.evaluate(s.ev, key, user...).Active
+}
+
+// IsActiveDetail - Check to see if a feature is active for a user and get the reason for the result
+func (s *Snapshot) IsActiveDetail(key string, user ...User) EvaluationDetail {
+	return s.client.evaluate(s.ev, key, user...)
+}
+
+// IsActiveCtx - Check to see if a feature is active for the user set in the context with WithUser
+func (s *Snapshot) IsActiveCtx(ctx context.Context, key string) bool {
+	return s.client.evaluateCtx(ctx, s.ev, key).Active
+}
+
+// IsActiveDetailCtx - Check to see if a feature is active for the user set in the context and get the reason for the result
+func (s *Snapshot) IsActiveDetailCtx(ctx context.Context, key string) EvaluationDetail {
+	return s.client.evaluateCtx(ctx, s.ev, key)
+}
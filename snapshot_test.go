@@ -0,0 +1,50 @@
+package molasses_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotIsNotChangedByNewFeatures(t *testing.T) {
+	var active int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if _, err := rw.Write([]byte(featurePayload(atomic.LoadInt32(&active) == 1))); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:       "API_KEY",
+		URL:          server.URL,
+		Polling:      true,
+		PollInterval: 5 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	snapshot := client.Snapshot()
+	assert.False(t, snapshot.IsActive("GOOGLE_SSO"))
+
+	atomic.StoreInt32(&active, 1)
+	assert.Eventually(t, func() bool { return client.IsActive("GOOGLE_SSO") }, time.Second, 5*time.Millisecond)
+
+	inactive := client.Usage()["GOOGLE_SSO"].Inactive
+	user := molasses.User{ID: "1234"}
+	assert.False(t, snapshot.IsActive("GOOGLE_SSO", user))
+	assert.Equal(t, molasses.ReasonFeatureInactive, snapshot.IsActiveDetail("GOOGLE_SSO", user).Reason)
+	ctx := molasses.WithUser(context.Background(), user)
+	assert.False(t, snapshot.IsActiveCtx(ctx, "GOOGLE_SSO"))
+	assert.Equal(t, molasses.ReasonFeatureInactive, snapshot.IsActiveDetailCtx(ctx, "GOOGLE_SSO").Reason)
+	assert.True(t, client.Snapshot().IsActive("GOOGLE_SSO", user))
+
+	// snapshot evaluations are counted like the client's
+	assert.Equal(t, inactive+4, client.Usage()["GOOGLE_SSO"].Inactive)
+}
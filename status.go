@@ -0,0 +1,107 @@
+package molasses
+
+import "time"
+
+const defaultStaleAfter = 5 * time.Minute
+
+// ConnectionState - The state of the client's connection to Molasses
+type ConnectionState string
+
+var (
+	StateInitializing ConnectionState = "INITIALIZING" // StateInitializing - no features have been received yet
+	StateValid        ConnectionState = "VALID"        // StateValid - the features are up to date
+	StateInterrupted  ConnectionState = "INTERRUPTED"  // StateInterrupted - the connection failed, the last features received are used
+	StateStale        ConnectionState = "STALE"        // StateStale - the connection has failed for longer than StaleAfter
+	StateOffline      ConnectionState = "OFFLINE"      // StateOffline - the client was stopped
+	StateUnauthorized ConnectionState = "UNAUTHORIZED" // StateUnauthorized - Molasses rejected the API key
+)
+
+// Status - The state of the client's connection and of the features it evaluates
+type Status struct {
+	State ConnectionState
+	// LastSuccess is when features were last received, or confirmed to be up to date
+	LastSuccess time.Time
+	LastError   error
+	LastErrorAt time.Time
+	// DataAge is the time since LastSuccess, it is zero while features are streamed
+	DataAge time.Duration
+}
+
+// Status - Returns the state of the connection to Molasses and the age of the features
+func (c *client) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.statusLocked()
+}
+
+func (c *client) statusLocked() Status {
+	s := Status{
+		LastSuccess: c.lastSuccess,
+		LastError:   c.lastError,
+		LastErrorAt: c.lastErrorAt,
+	}
+	if !c.lastSuccess.IsZero() && !(c.isStreamConnected && !c.pollingFallback) {
+		s.DataAge = time.Since(c.lastSuccess)
+	}
+	switch {
+	case c.stopped:
+		s.State = StateOffline
+	case c.lastError == ErrUnauthorized:
+		s.State = StateUnauthorized
+	case c.lastSuccess.IsZero():
+		s.State = StateInitializing
+	case c.healthy:
+		s.State = StateValid
+	case s.DataAge >= c.staleAfter:
+		s.State = StateStale
+	default:
+		s.State = StateInterrupted
+	}
+	return s
+}
+
+// recordSuccess records that features were received from the source currently in use
+func (c *client) recordSuccess() {
+	c.mu.Lock()
+	c.lastSuccess = time.Now()
+	c.healthy = true
+	if c.lastError == ErrUnauthorized {
+		c.lastError = nil
+	}
+	c.mu.Unlock()
+	c.updateStatus()
+}
+
+// recordError records a failure of the stream or of a poll, only failures of the source currently
+// in use make the features unhealthy
+func (c *client) recordError(err error, fromStream bool) {
+	c.mu.Lock()
+	if c.stopped {
+		// requests fail when they are cancelled by Stop
+		c.mu.Unlock()
+		return
+	}
+	c.lastError = err
+	c.lastErrorAt = time.Now()
+	if fromStream == !(c.polling || c.pollingFallback) {
+		c.healthy = false
+	}
+	c.mu.Unlock()
+	c.updateStatus()
+}
+
+// updateStatus calls OnStatusChange when the state changed since it was last called
+func (c *client) updateStatus() {
+	c.mu.Lock()
+	status := c.statusLocked()
+	changed := status.State != c.lastState
+	c.lastState = status.State
+	c.mu.Unlock()
+
+	if changed {
+		c.logger.Printf("Molasses is %s", status.State)
+		if c.onStatusChange != nil {
+			c.onStatusChange(status)
+		}
+	}
+}
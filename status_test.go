@@ -0,0 +1,116 @@
+package molasses_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// statusRecorder collects the states passed to OnStatusChange
+type statusRecorder struct {
+	mu     sync.Mutex
+	states []molasses.ConnectionState
+}
+
+func (r *statusRecorder) record(s molasses.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, s.State)
+}
+
+func (r *statusRecorder) recorded() []molasses.ConnectionState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]molasses.ConnectionState(nil), r.states...)
+}
+
+func TestStatusWhenPolling(t *testing.T) {
+	var failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if _, err := rw.Write([]byte(featurePayload(true))); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	recorder := &statusRecorder{}
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:         "API_KEY",
+		URL:            server.URL,
+		Polling:        true,
+		PollInterval:   10 * time.Millisecond,
+		StaleAfter:     200 * time.Millisecond,
+		OnStatusChange: recorder.record,
+	})
+	assert.NoError(t, err)
+
+	status := client.Status()
+	assert.Equal(t, molasses.StateValid, status.State)
+	assert.False(t, status.LastSuccess.IsZero())
+	assert.Nil(t, status.LastError)
+
+	atomic.StoreInt32(&failing, 1)
+	assert.Eventually(t, func() bool { return client.Status().State == molasses.StateInterrupted }, time.Second, 5*time.Millisecond)
+	assert.Error(t, client.Status().LastError)
+	assert.Eventually(t, func() bool {
+		states := recorder.recorded()
+		return states[len(states)-1] == molasses.StateStale
+	}, time.Second, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, int64(client.Status().DataAge), int64(200*time.Millisecond))
+
+	atomic.StoreInt32(&failing, 0)
+	assert.Eventually(t, func() bool { return client.Status().State == molasses.StateValid }, time.Second, 5*time.Millisecond)
+
+	client.Stop()
+	assert.Equal(t, molasses.StateOffline, client.Status().State)
+	assert.Equal(t, []molasses.ConnectionState{
+		molasses.StateValid,
+		molasses.StateInterrupted,
+		molasses.StateStale,
+		molasses.StateValid,
+		molasses.StateOffline,
+	}, recorder.recorded())
+}
+
+func TestStatusWhenUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	for _, polling := range []bool{true, false} {
+		client, err := molasses.Init(molasses.ClientOptions{
+			APIKey:  "API_KEY",
+			URL:     server.URL,
+			Polling: polling,
+		})
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool { return client.Status().State == molasses.StateUnauthorized }, 2*time.Second, 5*time.Millisecond)
+		assert.False(t, client.IsInitiated())
+		client.Stop()
+	}
+}
+
+func TestStatusWhenStreaming(t *testing.T) {
+	server := newStreamServer(t, true)
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey: "API_KEY",
+		URL:    server.URL,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	assert.Equal(t, molasses.StateInitializing, client.Status().State)
+	assert.Eventually(t, func() bool { return client.Status().State == molasses.StateValid }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, time.Duration(0), client.Status().DataAge)
+}
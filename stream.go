@@ -0,0 +1,110 @@
+package molasses
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	sse "github.com/r3labs/sse/v2"
+)
+
+const (
+	defaultPollInterval         = 15 * time.Second
+	defaultPollingFallbackAfter = time.Minute
+	// resubscribeDelay is how long to wait before subscribing again to a stream the server ended
+	resubscribeDelay = time.Second
+)
+
+var errStreamEnded = errors.New("Molasses ended the event stream")
+
+// stream subscribes to the event stream until the client is stopped. The SSE client reconnects
+// after errors by itself, but a stream that the server ends is subscribed to again here.
+func (c *client) stream(ctx context.Context) {
+	for {
+		err := c.sseClient.SubscribeWithContext(ctx, "messages", c.handleStreamEvent)
+		if ctx.Err() != nil {
+			return
+		}
+		c.streamDisconnected(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+func (c *client) handleStreamEvent(msg *sse.Event) {
+	if len(msg.Data) == 0 {
+		// keep-alive comments, such as ": ping", are events without data
+		return
+	}
+	data, err := decodePayload(msg.Data)
+	if err != nil {
+		// the features received before are kept
+		c.reportError("Error refreshing features - %s", err)
+		c.recordError(err, true)
+		return
+	}
+	c.loadFeatures(data)
+
+	c.mu.Lock()
+	if c.pollingFallback {
+		c.logger.Println("Molasses stream recovered, stopped polling")
+		c.pollingFallback = false
+	} else if !c.isStreamConnected {
+		c.logger.Println("Molasses is connected")
+	}
+	if !c.initiated {
+		c.logger.Println("Molasses is initiated")
+	}
+	c.isStreamConnected = true
+	c.initiated = true
+	c.mu.Unlock()
+	c.recordSuccess()
+}
+
+// streamDisconnected records that the stream stopped, the failure window starts at the first failure
+func (c *client) streamDisconnected(err error) {
+	c.mu.Lock()
+	wasConnected := c.isStreamConnected
+	if wasConnected {
+		c.logger.Printf("Client disconnected")
+		c.isStreamConnected = false
+		c.streamDownSince = time.Now()
+	}
+	c.mu.Unlock()
+	if err != nil {
+		c.recordError(err, true)
+	} else if wasConnected {
+		c.recordError(errStreamEnded, true)
+	}
+}
+
+// streamFailed is notified by the SSE client of every failed attempt to connect or stay connected
+func (c *client) streamFailed(err error, backoff time.Duration) {
+	c.logger.Println("Reconnect", err, backoff)
+	if c.onError != nil {
+		c.onError(err)
+	}
+	c.metrics.IncCounter(MetricStreamReconnects)
+	c.streamDisconnected(err)
+}
+
+// shouldPoll reports whether features are fetched on the refresh interval: always when polling,
+// and when streaming once the stream has been down for longer than the fallback window
+func (c *client) shouldPoll() bool {
+	if c.polling {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isStreamConnected || time.Since(c.streamDownSince) < c.pollingFallbackAfter {
+		return false
+	}
+	if !c.pollingFallback {
+		c.logger.Println("Molasses stream is unavailable, falling back to polling")
+		c.pollingFallback = true
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package molasses_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func featurePayload(active bool) string {
+	return fmt.Sprintf(`{"data":{"features":[{"id":"1","key":"GOOGLE_SSO","active":%t,"segments":[{"segmentType":"everyoneElse","percentage":100}]}]}}`, active)
+}
+
+// streamServer serves the event stream while streaming is set, and fails like a proxy that blocks it otherwise
+type streamServer struct {
+	*httptest.Server
+	streaming int32
+	polls     int32
+}
+
+func newStreamServer(t *testing.T, streaming bool) *streamServer {
+	s := &streamServer{}
+	if streaming {
+		s.streaming = 1
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/features":
+			atomic.AddInt32(&s.polls, 1)
+			if _, err := rw.Write([]byte(featurePayload(false))); err != nil {
+				t.Error(err)
+			}
+		case "/event-stream":
+			if atomic.LoadInt32(&s.streaming) == 0 {
+				rw.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			rw.Header().Set("Content-Type", "text/event-stream")
+			if _, err := fmt.Fprintf(rw, "data: %s\n\n", featurePayload(true)); err != nil {
+				t.Error(err)
+			}
+			rw.(http.Flusher).Flush()
+			<-req.Context().Done()
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestStreaming(t *testing.T) {
+	server := newStreamServer(t, true)
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey: "API_KEY",
+		URL:    server.URL,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	assert.Eventually(t, client.IsInitiated, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, client.IsActive("GOOGLE_SSO"))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&server.polls))
+}
+
+func TestStreamingFallsBackToPolling(t *testing.T) {
+	server := newStreamServer(t, false)
+	start := time.Now()
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:               "API_KEY",
+		URL:                  server.URL,
+		PollInterval:         10 * time.Millisecond,
+		PollingFallbackAfter: 100 * time.Millisecond,
+	})
+	assert.NoError(t, err, "Init does not wait for the stream")
+	defer client.Stop()
+
+	assert.Eventually(t, client.IsInitiated, 2*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond), "polling only starts after the failure window")
+	assert.False(t, client.IsActive("GOOGLE_SSO"))
+
+	// once the stream recovers the client stops polling
+	atomic.StoreInt32(&server.streaming, 1)
+	streaming := func() bool {
+		status := client.Status()
+		return status.State == molasses.StateValid && status.DataAge == 0
+	}
+	assert.Eventually(t, streaming, 10*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	polls := atomic.LoadInt32(&server.polls)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, polls, atomic.LoadInt32(&server.polls))
+}
@@ -0,0 +1,204 @@
+package molasses
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FlagUsage - How a feature has been evaluated by IsActive and IsActiveDetail since the client started
+type FlagUsage struct {
+	Key         string
+	Evaluations int64
+	Active      int64
+	Inactive    int64
+	// Assignments counts the evaluations by the assignment the user was given
+	Assignments map[Assignment]int64
+	// DistinctUsers is an estimate of the number of users the feature was evaluated for
+	DistinctUsers  uint64
+	FirstEvaluated time.Time
+	// LastEvaluated is accurate to a second, it is not moved for every evaluation
+	LastEvaluated time.Time
+}
+
+// flagUsage holds the counters of a feature, it is shared by every evaluator compiled for the feature.
+// Evaluations are counted with atomic operations, so concurrent evaluations of a feature do not wait on each other.
+type flagUsage struct {
+	// firstEvaluated and lastEvaluated are Unix nanoseconds, lastEvaluated is only moved once a second
+	firstEvaluated int64
+	lastEvaluated  int64
+	// reported is the number of evaluations in the last summary event
+	reported    int64
+	key         string
+	mu          sync.Mutex   // mu - guards adding an assignment to assignments
+	assignments atomic.Value // assignments - the []*assignmentCount of every assignment evaluated so far
+	users       hyperLogLog
+}
+
+type assignmentCount struct {
+	count      int64
+	assignment Assignment
+}
+
+func newFlagUsage(key string) *flagUsage {
+	u := &flagUsage{key: key}
+	u.assignments.Store([]*assignmentCount(nil))
+	return u
+}
+
+// record counts an evaluation, it does not allocate once the assignment has been counted before
+func (u *flagUsage) record(detail *EvaluationDetail, user *User) {
+	if u == nil {
+		return
+	}
+	atomic.AddInt64(&u.counter(detail.Assignment).count, 1)
+	if user != nil {
+		u.users.add(sampleHash(user.ID))
+	}
+	now := time.Now().UnixNano()
+	if atomic.LoadInt64(&u.firstEvaluated) == 0 {
+		atomic.CompareAndSwapInt64(&u.firstEvaluated, 0, now)
+	}
+	if last := atomic.LoadInt64(&u.lastEvaluated); now-last >= int64(time.Second) {
+		atomic.CompareAndSwapInt64(&u.lastEvaluated, last, now)
+	}
+}
+
+// counter returns the counter of the assignment, adding it the first time the assignment is evaluated
+func (u *flagUsage) counter(assignment Assignment) *assignmentCount {
+	for _, c := range u.assignments.Load().([]*assignmentCount) {
+		if c.assignment == assignment {
+			return c
+		}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts := u.assignments.Load().([]*assignmentCount)
+	for _, c := range counts {
+		if c.assignment == assignment {
+			return c
+		}
+	}
+	c := &assignmentCount{assignment: assignment}
+	u.assignments.Store(append(counts[:len(counts):len(counts)], c))
+	return c
+}
+
+// snapshot copies the counters, a feature is active for every assignment but control
+func (u *flagUsage) snapshot() FlagUsage {
+	counts := u.assignments.Load().([]*assignmentCount)
+	s := FlagUsage{Key: u.key, Assignments: make(map[Assignment]int64, len(counts))}
+	for _, c := range counts {
+		n := atomic.LoadInt64(&c.count)
+		s.Assignments[c.assignment] = n
+		s.Evaluations += n
+		if c.assignment == AssignmentControl {
+			s.Inactive += n
+		} else {
+			s.Active += n
+		}
+	}
+	s.DistinctUsers = u.users.estimate()
+	if first := atomic.LoadInt64(&u.firstEvaluated); first != 0 {
+		s.FirstEvaluated = time.Unix(0, first)
+	}
+	if last := atomic.LoadInt64(&u.lastEvaluated); last != 0 {
+		s.LastEvaluated = time.Unix(0, last)
+	}
+	return s
+}
+
+// usageTracker keeps the usage of every feature across payloads
+type usageTracker struct {
+	mu    sync.Mutex
+	flags map[string]*flagUsage
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{flags: map[string]*flagUsage{}}
+}
+
+// attach gives every feature of a newly compiled evaluator its usage counters
+func (t *usageTracker) attach(ev *evaluator) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, f := range ev.features {
+		u, ok := t.flags[key]
+		if !ok {
+			u = newFlagUsage(key)
+			t.flags[key] = u
+		}
+		f.usage = u
+	}
+}
+
+func (t *usageTracker) all() []*flagUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	flags := make([]*flagUsage, 0, len(t.flags))
+	for _, u := range t.flags {
+		flags = append(flags, u)
+	}
+	return flags
+}
+
+// Usage - Returns how every feature has been evaluated since the client started,
+// features that were never evaluated have no evaluations so they can be found and deleted
+func (c *client) Usage() map[string]FlagUsage {
+	usage := map[string]FlagUsage{}
+	for _, u := range c.usage.all() {
+		s := u.snapshot()
+		usage[s.Key] = s
+	}
+	return usage
+}
+
+// sendUsageSummaries sends a flag_usage event for every feature evaluated since the previous summary
+func (c *client) sendUsageSummaries() {
+	ev := c.evaluator()
+	for _, u := range c.usage.all() {
+		s := u.snapshot()
+		if s.Evaluations <= atomic.SwapInt64(&u.reported, s.Evaluations) {
+			continue
+		}
+		assignments := make(map[string]interface{}, len(s.Assignments))
+		for a, n := range s.Assignments {
+			assignments[string(a)] = n
+		}
+		e := eventOptions{
+			Event:       "flag_usage",
+			FeatureName: s.Key,
+			SampleRate:  1,
+			Tags: map[string]interface{}{
+				"evaluations":    s.Evaluations,
+				"active":         s.Active,
+				"inactive":       s.Inactive,
+				"assignments":    assignments,
+				"distinctUsers":  s.DistinctUsers,
+				"firstEvaluated": s.FirstEvaluated,
+				"lastEvaluated":  s.LastEvaluated,
+				"since":          c.startedAt,
+			},
+		}
+		if f, ok := ev.features[s.Key]; ok {
+			e.FeatureID = f.ID
+		}
+		if err := c.queueOrSendEvent(e); err != nil {
+			c.reportError("Error uploading event- %s", err)
+		}
+	}
+}
+
+// summarizeUsage sends usage summaries every interval until the client is stopped
+func (c *client) summarizeUsage(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sendUsageSummaries()
+		case <-c.done:
+			return
+		}
+	}
+}
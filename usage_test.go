@@ -0,0 +1,100 @@
+package molasses_test
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsage(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+	before := time.Now()
+	for i := 0; i < 100; i++ {
+		client.IsActive("CHECKOUT", molasses.User{ID: strconv.Itoa(i % 10), Params: map[string]interface{}{"plan": "team"}})
+	}
+	client.IsActive("CHECKOUT", molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "enterprise"}})
+	client.IsActive("CHECKOUT")
+
+	usage := client.Usage()["CHECKOUT"]
+	assert.Equal(t, "CHECKOUT", usage.Key)
+	assert.Equal(t, int64(102), usage.Evaluations)
+	assert.Equal(t, int64(102), usage.Active)
+	assert.Equal(t, int64(0), usage.Inactive)
+	assert.Equal(t, map[molasses.Assignment]int64{
+		molasses.AssignmentExperiment: 101,
+		"one-page":                    1,
+	}, usage.Assignments)
+	assert.Equal(t, uint64(11), usage.DistinctUsers)
+	assert.False(t, usage.FirstEvaluated.Before(before))
+	assert.False(t, usage.LastEvaluated.Before(usage.FirstEvaluated))
+
+	// the usage is a copy
+	usage.Assignments[molasses.AssignmentControl] = 5
+	assert.NotContains(t, client.Usage()["CHECKOUT"].Assignments, molasses.AssignmentControl)
+}
+
+func TestUsageCountsConcurrentEvaluations(t *testing.T) {
+	client, _ := newEventsClient(t, molasses.ClientOptions{}, assignmentPayload)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				plan := "team"
+				if i%5 == 0 {
+					plan = "free"
+				}
+				client.IsActive("CHECKOUT", molasses.User{ID: strconv.Itoa(g), Params: map[string]interface{}{"plan": plan}})
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	usage := client.Usage()["CHECKOUT"]
+	assert.Equal(t, int64(4000), usage.Evaluations)
+	assert.Equal(t, int64(800), usage.Inactive)
+	assert.Equal(t, int64(3200), usage.Active)
+	assert.Equal(t, uint64(8), usage.DistinctUsers)
+}
+
+func TestUsageIsKeptAcrossPayloads(t *testing.T) {
+	server := newStreamServer(t, false)
+	client, err := molasses.Init(molasses.ClientOptions{
+		APIKey:       "API_KEY",
+		URL:          server.URL,
+		Polling:      true,
+		PollInterval: 5 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	defer client.Stop()
+
+	client.IsActive("GOOGLE_SSO")
+	polls := atomic.LoadInt32(&server.polls)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&server.polls) > polls+1 }, time.Second, 5*time.Millisecond)
+	client.IsActive("GOOGLE_SSO")
+	assert.Equal(t, int64(2), client.Usage()["GOOGLE_SSO"].Evaluations)
+}
+
+func TestUsageSummaries(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{UsageSummaryInterval: time.Hour}, assignmentPayload)
+	client.IsActive("CHECKOUT", molasses.User{ID: "1234", Params: map[string]interface{}{"plan": "team"}})
+	client.IsActive("CHECKOUT", molasses.User{ID: "5678", Params: map[string]interface{}{"plan": "free"}})
+
+	// stopping the client sends the last summary
+	client.Stop()
+	event := <-events
+	assert.Equal(t, "flag_usage", event.Event)
+	assert.Equal(t, 1.0, event.SampleRate)
+	assert.Equal(t, 2.0, event.Tags["evaluations"])
+	assert.Equal(t, 1.0, event.Tags["active"])
+	assert.Equal(t, 1.0, event.Tags["inactive"])
+	assert.Equal(t, map[string]interface{}{"experiment": 1.0, "control": 1.0}, event.Tags["assignments"])
+	assert.Equal(t, 2.0, event.Tags["distinctUsers"])
+	assert.Contains(t, event.Tags, "since")
+}
@@ -0,0 +1,195 @@
+package molasses
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// User - The representation of your user
+type User struct {
+	ID     string
+	Params map[string]interface{}
+	// Private - The params that are used for evaluation but are never sent to Molasses in events
+	Private []string
+}
+
+// InvalidParamError - Returned by UserBuilder.Build when a param has a value that can not be used in constraints
+type InvalidParamError struct {
+	Key    string
+	Value  interface{}
+	Reason string
+}
+
+func (e *InvalidParamError) Error() string {
+	return fmt.Sprintf("user param %s is invalid - %s", e.Key, e.Reason)
+}
+
+// UserBuilder - Builds a User with typed params. The builder never keeps or mutates maps and slices
+// that are passed to it, and every User it builds has its own copy of the params.
+type UserBuilder struct {
+	id      string
+	params  map[string]interface{}
+	private []string
+	err     error
+}
+
+// NewUser - Starts building a user with the ID that is used for percentage rollouts
+func NewUser(id string) *UserBuilder {
+	return &UserBuilder{id: id, params: map[string]interface{}{}}
+}
+
+// String - Sets a string param
+func (b *UserBuilder) String(key string, value string) *UserBuilder {
+	b.params[key] = value
+	return b
+}
+
+// Number - Sets a number param
+func (b *UserBuilder) Number(key string, value float64) *UserBuilder {
+	b.params[key] = value
+	return b
+}
+
+// Bool - Sets a bool param
+func (b *UserBuilder) Bool(key string, value bool) *UserBuilder {
+	b.params[key] = value
+	return b
+}
+
+// Time - Sets a time param, it is compared as Unix seconds by number constraints and as RFC 3339 by string constraints
+func (b *UserBuilder) Time(key string, value time.Time) *UserBuilder {
+	b.params[key] = value
+	return b
+}
+
+// Strings - Sets a list of strings, string constraints match when any of the values matches
+// and negated constraints such as nin match when none of them do
+func (b *UserBuilder) Strings(key string, values ...string) *UserBuilder {
+	b.params[key] = append([]string(nil), values...)
+	return b
+}
+
+// Version - Sets a semantic version, such as 2.3 or v2.3.0
+func (b *UserBuilder) Version(key string, version string) *UserBuilder {
+	v, ok := normalizeVersion(version)
+	if !ok {
+		b.fail(&InvalidParamError{Key: key, Value: version, Reason: fmt.Sprintf("%q is not a semantic version", version)})
+		return b
+	}
+	b.params[key] = v
+	return b
+}
+
+// Param - Sets a param of any supported type: strings, bools, Go integer and float types,
+// json.Number, time.Duration, time.Time and []string
+func (b *UserBuilder) Param(key string, value interface{}) *UserBuilder {
+	if !isSupportedParam(value) {
+		b.fail(&InvalidParamError{Key: key, Value: value, Reason: fmt.Sprintf("values of type %T are not supported", value)})
+		return b
+	}
+	if values, ok := value.([]string); ok {
+		return b.Strings(key, values...)
+	}
+	b.params[key] = value
+	return b
+}
+
+// Params - Sets every param in the map, see Param for the supported types
+func (b *UserBuilder) Params(params map[string]interface{}) *UserBuilder {
+	for key, value := range params {
+		b.Param(key, value)
+	}
+	return b
+}
+
+// Private - Marks params as private, they are used for evaluation but are never sent to Molasses in events
+func (b *UserBuilder) Private(keys ...string) *UserBuilder {
+	b.private = append(b.private, keys...)
+	return b
+}
+
+// Build - Returns the user, or the first error found while setting its params
+func (b *UserBuilder) Build() (User, error) {
+	if b.err != nil {
+		return User{}, b.err
+	}
+	user := User{ID: b.id, Params: copyParams(b.params)}
+	if len(b.private) > 0 {
+		user.Private = append([]string(nil), b.private...)
+	}
+	return user, nil
+}
+
+func (b *UserBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func isSupportedParam(value interface{}) bool {
+	switch value.(type) {
+	case json.Number, time.Duration, time.Time, []string:
+		return true
+	case nil:
+		return false
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// copyParams returns a copy of the params, with room for extra entries, so they can be changed
+// without touching the caller's map
+func copyParams(params map[string]interface{}, extra ...map[string]interface{}) map[string]interface{} {
+	size := len(params)
+	for _, e := range extra {
+		size += len(e)
+	}
+	copied := make(map[string]interface{}, size)
+	for k, v := range params {
+		copied[k] = v
+	}
+	for _, e := range extra {
+		for k, v := range e {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// redactParams removes private params from event tags, or replaces them with an HMAC-SHA-256 of
+// their value when a hash key is set so they can still be grouped by. A plain hash is not used as
+// values such as IP addresses and emails could be recovered from it by trying every candidate.
+func redactParams(tags map[string]interface{}, hashKey []byte, private ...[]string) {
+	hashed := map[string]bool{}
+	for _, keys := range private {
+		for _, key := range keys {
+			value, ok := tags[key]
+			if !ok || hashed[key] {
+				continue
+			}
+			if len(hashKey) == 0 {
+				delete(tags, key)
+				continue
+			}
+			s, err := toString(value, false)
+			if err != nil {
+				s = fmt.Sprint(value)
+			}
+			mac := hmac.New(sha256.New, hashKey)
+			mac.Write([]byte(s))
+			tags[key] = hex.EncodeToString(mac.Sum(nil))
+			hashed[key] = true
+		}
+	}
+}
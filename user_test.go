@@ -0,0 +1,147 @@
+package molasses_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/molassesapp/molasses-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserBuilder(t *testing.T) {
+	signedUp := time.Date(2020, 8, 26, 2, 11, 44, 0, time.UTC)
+	roles := []string{"admin", "billing"}
+	user, err := molasses.NewUser("1234").
+		String("plan", "enterprise").
+		Number("seats", 50).
+		Bool("beta", true).
+		Time("signedUp", signedUp).
+		Strings("roles", roles...).
+		Version("appVersion", "2.3").
+		Param("teamId", int64(12356)).
+		Build()
+	assert.NoError(t, err)
+	assert.Equal(t, molasses.User{
+		ID: "1234",
+		Params: map[string]interface{}{
+			"plan":       "enterprise",
+			"seats":      50.0,
+			"beta":       true,
+			"signedUp":   signedUp,
+			"roles":      []string{"admin", "billing"},
+			"appVersion": "v2.3.0",
+			"teamId":     int64(12356),
+		},
+	}, user)
+
+	roles[0] = "viewer"
+	assert.Equal(t, []string{"admin", "billing"}, user.Params["roles"])
+}
+
+func TestUserBuilderCopiesParams(t *testing.T) {
+	params := map[string]interface{}{"plan": "team"}
+	builder := molasses.NewUser("1234").Params(params)
+	first, err := builder.Build()
+	assert.NoError(t, err)
+
+	builder.String("plan", "enterprise")
+	second, err := builder.Build()
+	assert.NoError(t, err)
+
+	first.Params["seats"] = 5
+	assert.Equal(t, map[string]interface{}{"plan": "team"}, params)
+	assert.Equal(t, "team", first.Params["plan"])
+	assert.Equal(t, map[string]interface{}{"plan": "enterprise"}, second.Params)
+}
+
+func TestUserBuilderValidation(t *testing.T) {
+	_, err := molasses.NewUser("1234").Param("address", map[string]string{"city": "Austin"}).Build()
+	invalid, ok := err.(*molasses.InvalidParamError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "address", invalid.Key)
+	}
+
+	_, err = molasses.NewUser("1234").Version("appVersion", "latest").Build()
+	assert.Error(t, err)
+
+	_, err = molasses.NewUser("1234").Params(map[string]interface{}{"teamId": nil}).Build()
+	assert.Error(t, err)
+}
+
+func TestTrackDoesNotMutateUser(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{}, eventTagsPayload)
+
+	params := map[string]interface{}{"plan": "team"}
+	client.Track("Checkout Started", molasses.User{ID: "1234", Params: params}, map[string]interface{}{"button": "green"})
+	assert.Equal(t, map[string]interface{}{"plan": "team"}, params)
+	assert.Equal(t, map[string]interface{}{"plan": "team", "button": "green"}, (<-events).Tags)
+
+	// users without params used to panic
+	assert.NotPanics(t, func() {
+		client.Track("Checkout Started", molasses.User{ID: "1234"}, map[string]interface{}{"button": "green"})
+		client.ExperimentStarted("GOOGLE_SSO", molasses.User{ID: "1234"}, map[string]interface{}{"button": "green"})
+		client.ExperimentSuccess("GOOGLE_SSO", molasses.User{ID: "1234"}, nil)
+	})
+	for i := 0; i < 3; i++ {
+		<-events
+	}
+}
+
+func TestPrivateAttributesAreNotSent(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{
+		AutoSendEvents:    true,
+		PrivateAttributes: []string{"email"},
+		ExposureCacheSize: -1,
+	}, eventTagsPayload)
+	user, err := molasses.NewUser("1234").
+		String("email", "jane@example.com").
+		String("ip", "10.0.0.1").
+		String("plan", "team").
+		Private("ip").
+		Build()
+	assert.NoError(t, err)
+	expected := map[string]interface{}{"plan": "team"}
+
+	assert.True(t, client.IsActive("GOOGLE_SSO", user))
+	assert.Equal(t, expected, (<-events).Tags)
+	client.Track("Checkout Started", user, nil)
+	assert.Equal(t, expected, (<-events).Tags)
+	client.ExperimentStarted("GOOGLE_SSO", user, nil)
+	assert.Equal(t, expected, (<-events).Tags)
+	client.ExperimentSuccess("GOOGLE_SSO", user, map[string]interface{}{"email": "jane@example.com"})
+	assert.Equal(t, expected, (<-events).Tags)
+	assert.Equal(t, "jane@example.com", user.Params["email"])
+}
+
+func TestPrivateAttributesAreHashed(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{
+		PrivateAttributes:        []string{"email"},
+		PrivateAttributesHashKey: []byte("event-secret"),
+	}, eventTagsPayload)
+	user := molasses.User{
+		ID:      "1234",
+		Params:  map[string]interface{}{"email": "jane@example.com", "seats": 5},
+		Private: []string{"email", "seats"},
+	}
+
+	client.Track("Checkout Started", user, nil)
+	assert.Equal(t, map[string]interface{}{
+		// hashed once even though email is private in both places
+		"email": "04a450dbce9c73f23f894bc38728bfdce263c5b2788df5abf95a88b64351e422",
+		"seats": "5ec344c3fa008e53904fbc947feb1ca6bf3b9e98738100c7bbf4caf12b267c96",
+	}, (<-events).Tags)
+}
+
+func TestPrivateAttributesAreNotHashedWithoutKey(t *testing.T) {
+	client, events := newEventsClient(t, molasses.ClientOptions{
+		PrivateAttributesHashKey: []byte{},
+	}, eventTagsPayload)
+	user := molasses.User{
+		ID:      "1234",
+		Params:  map[string]interface{}{"email": "jane@example.com", "plan": "team"},
+		Private: []string{"email"},
+	}
+
+	client.Track("Checkout Started", user, nil)
+	assert.Equal(t, map[string]interface{}{"plan": "team"}, (<-events).Tags)
+}
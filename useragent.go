@@ -0,0 +1,54 @@
+package molasses
+
+import "strings"
+
+// userAgentPlatforms are matched in order, the version follows the marker when there is one
+var userAgentPlatforms = []struct {
+	contains string
+	marker   string
+	platform string
+}{
+	{"iPhone", "iPhone OS ", "ios"},
+	{"iPad", "CPU OS ", "ios"},
+	{"iPod", "iPhone OS ", "ios"},
+	{"Android", "Android ", "android"},
+	{"Windows", "Windows NT ", "windows"},
+	{"CrOS", "CrOS ", "chromeos"},
+	{"Mac OS X", "Mac OS X ", "macos"},
+	{"Linux", "", "linux"},
+}
+
+// parseUserAgent returns the operating system of a User-Agent and its version, when it has one
+func parseUserAgent(ua string) (platform string, version string) {
+	for _, p := range userAgentPlatforms {
+		if !strings.Contains(ua, p.contains) {
+			continue
+		}
+		if p.marker != "" {
+			version = versionAfter(ua, p.marker)
+		}
+		return p.platform, version
+	}
+	return "", ""
+}
+
+// versionAfter reads the dotted or underscored version that follows the marker, as in iPhone OS 17_1. The
+// architecture that Chrome OS puts before its version, as in CrOS x86_64 14541.0.0, is skipped.
+func versionAfter(ua string, marker string) string {
+	i := strings.Index(ua, marker)
+	if i < 0 {
+		return ""
+	}
+	rest := ua[i+len(marker):]
+	if rest != "" && (rest[0] < '0' || rest[0] > '9') {
+		if j := strings.IndexByte(rest, ' '); j >= 0 {
+			rest = rest[j+1:]
+		}
+	}
+	if j := strings.IndexFunc(rest, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '_'
+	}); j >= 0 {
+		rest = rest[:j]
+	}
+	return strings.Trim(strings.Replace(rest, "_", ".", -1), ".")
+}